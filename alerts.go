@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AlertsConfig configures alerts on the total portfolio value
+type AlertsConfig struct {
+	// Milestones are total values alerted on once when crossed upwards and once when crossed downwards
+	Milestones []float64 `toml:"Milestones"`
+	// ATH alerts on a new all-time-high total value
+	ATH bool `toml:"ATH"`
+	// ATHRearmPercent is how far the total must fall below its high before a
+	// new high alerts again, defaulting to 5
+	ATHRearmPercent float64 `toml:"ATHRearmPercent"`
+	// BalanceChanges alerts when a held amount changes between updates
+	BalanceChanges bool `toml:"BalanceChanges"`
+	// BalanceChangePercent ignores changes smaller than this percentage of the
+	// previous amount, alerting on any change when 0
+	BalanceChangePercent float64 `toml:"BalanceChangePercent"`
+	// Webhook receives notifications as a JSON {"text": ...} payload
+	Webhook string `toml:"Webhook"`
+	// State is the path alert state is persisted to, defaulting to alerts.json
+	State string `toml:"State"`
+}
+
+// AlertState is what has fired so far, persisted so each alert fires exactly once across restarts
+type AlertState struct {
+	// Fired records when each milestone alert fired, keyed by MilestoneKey
+	Fired    map[string]time.Time `json:"fired"`
+	Previous float64              `json:"previous"`
+	ATH      float64              `json:"ath"`
+	ATHArmed bool                 `json:"ath_armed"`
+}
+
+var alertState *AlertState
+
+// alertMu guards alertState between the updater and the API
+var alertMu sync.Mutex
+
+// AlertStatus is the current state of a configured alert
+type AlertStatus struct {
+	Name      string  `json:"name"`
+	Active    bool    `json:"active"`
+	Threshold float64 `json:"threshold"`
+	Value     float64 `json:"value"`
+	// FiredAt is when the one-shot notification was sent, if it has been
+	FiredAt *time.Time `json:"fired_at,omitempty"`
+}
+
+var alertActiveGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "alert_active",
+	Help:      "Whether an alert's condition currently holds",
+}, []string{"alert"})
+
+var alertThresholdGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "alert_threshold",
+	Help:      "Threshold an alert compares the total portfolio value against",
+}, []string{"alert"})
+
+var alertValueGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "alert_value",
+	Help:      "Current value an alert is evaluated on",
+}, []string{"alert"})
+
+var alertFiredGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "alert_fired",
+	Help:      "Whether an alert's one-shot notification has been sent",
+}, []string{"alert"})
+
+// PrepareAlertGauges registers the alert state metrics
+func PrepareAlertGauges() {
+	prometheus.Register(alertActiveGauge)
+	prometheus.Register(alertThresholdGauge)
+	prometheus.Register(alertValueGauge)
+	prometheus.Register(alertFiredGauge)
+}
+
+// LoadAlertState reads the persisted alert state, seeding the high from the
+// snapshot history when there is none yet
+func LoadAlertState(config *Config) (*AlertState, error) {
+	state := &AlertState{Fired: map[string]time.Time{}}
+	b, err := ioutil.ReadFile(LiveAlerts(config).State)
+	if os.IsNotExist(err) {
+		snapshots, err := snapshotStore.Since(time.Time{})
+		if err != nil {
+			return nil, err
+		}
+		for _, snapshot := range snapshots {
+			if snapshot.Total > state.ATH {
+				state.ATH = snapshot.Total
+			}
+		}
+		if len(snapshots) > 0 {
+			state.Previous = snapshots[len(snapshots)-1].Total
+		}
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, state)
+	if err != nil {
+		return nil, err
+	}
+	if state.Fired == nil {
+		state.Fired = map[string]time.Time{}
+	}
+	return state, nil
+}
+
+// SaveAlertState persists the alert state
+func SaveAlertState(config *Config, state *AlertState) error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(LiveAlerts(config).State, b, 0600)
+}
+
+// MilestoneKey identifies a milestone alert in a direction
+func MilestoneKey(milestone float64, up bool) string {
+	if up {
+		return "milestone:up:" + FormatFloat(milestone)
+	}
+	return "milestone:down:" + FormatFloat(milestone)
+}
+
+// CheckAlerts compares the latest total with the previous one and fires any
+// milestone or all-time-high alerts that haven't fired before
+func CheckAlerts(config *Config) {
+	p := LoadPortfolio()
+	if p.UpdatedAt.IsZero() || alertState == nil {
+		return
+	}
+	alertMu.Lock()
+	defer alertMu.Unlock()
+	state := alertState
+	alerts := LiveAlerts(config)
+	now := time.Now()
+	total := p.Total
+	changed := state.Previous != total
+
+	if state.Previous > 0 {
+		for _, milestone := range alerts.Milestones {
+			up := state.Previous < milestone && total >= milestone
+			down := state.Previous >= milestone && total < milestone
+			if !up && !down {
+				continue
+			}
+			key := MilestoneKey(milestone, up)
+			if _, fired := state.Fired[key]; fired {
+				continue
+			}
+			state.Fired[key] = now
+			direction := "fell below"
+			if up {
+				direction = "crossed above"
+			}
+			FireAlert(config, fmt.Sprintf("Portfolio %s %s %s, now %s %s", direction, FormatValue(config, milestone, p.Currency), p.Currency, FormatValue(config, total, p.Currency), p.Currency))
+		}
+	}
+
+	if total > state.ATH {
+		if alerts.ATH && state.ATHArmed && state.ATH > 0 {
+			FireAlert(config, fmt.Sprintf("Portfolio reached a new all-time high of %s %s", FormatValue(config, total, p.Currency), p.Currency))
+		}
+		state.ATH = total
+		state.ATHArmed = false
+		changed = true
+	} else if !state.ATHArmed && BelowPercent(total, state.ATH) >= alerts.ATHRearmPercent {
+		state.ATHArmed = true
+		changed = true
+	}
+
+	state.Previous = total
+	if changed {
+		err := SaveAlertState(config, state)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	for _, status := range AlertStatuses(config, state, total) {
+		alertActiveGauge.WithLabelValues(status.Name).Set(BoolFloat(status.Active))
+		alertThresholdGauge.WithLabelValues(status.Name).Set(status.Threshold)
+		alertValueGauge.WithLabelValues(status.Name).Set(status.Value)
+		alertFiredGauge.WithLabelValues(status.Name).Set(BoolFloat(status.FiredAt != nil))
+	}
+}
+
+// AlertStatuses evaluates every configured alert against a total value
+func AlertStatuses(config *Config, state *AlertState, total float64) []AlertStatus {
+	statuses := []AlertStatus{}
+	alerts := LiveAlerts(config)
+	for _, milestone := range alerts.Milestones {
+		for _, up := range []bool{true, false} {
+			status := AlertStatus{
+				Name:      MilestoneKey(milestone, up),
+				Active:    total >= milestone,
+				Threshold: milestone,
+				Value:     total,
+			}
+			if !up {
+				status.Active = total < milestone
+			}
+			if fired, ok := state.Fired[status.Name]; ok {
+				status.FiredAt = &fired
+			}
+			statuses = append(statuses, status)
+		}
+	}
+	if alerts.ATH {
+		statuses = append(statuses, AlertStatus{
+			Name:      "ath",
+			Active:    state.ATH > 0 && total >= state.ATH,
+			Threshold: state.ATH,
+			Value:     total,
+		})
+	}
+	return statuses
+}
+
+// GetAlertsJSON returns the state of every configured alert as JSON
+func GetAlertsJSON(config *Config) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		p := LoadPortfolio()
+		statuses := []AlertStatus{}
+		alertMu.Lock()
+		if alertState != nil {
+			statuses = AlertStatuses(config, alertState, p.Total)
+		}
+		alertMu.Unlock()
+		for i := range statuses {
+			statuses[i].Threshold = Round(config, statuses[i].Threshold, ValueDecimals(config, p.Currency))
+			statuses[i].Value = Round(config, statuses[i].Value, ValueDecimals(config, p.Currency))
+		}
+		b, err := json.Marshal(statuses)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Alerts can be reconfigured or fire between updates, so the ETag
+		// also covers a hash of the statuses
+		hash := fnv.New64a()
+		hash.Write(b)
+		if NotModified(w, r, p.UpdatedAt, strconv.FormatUint(hash.Sum64(), 36)) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(append(b, '\n'))
+	}
+
+	return fn
+}
+
+// BoolFloat converts a bool to 1 or 0 for a gauge
+func BoolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// FireAlert sends an alert notification and records it as an event
+func FireAlert(config *Config, text string) {
+	Notify(config, text)
+	RecordEvent(config, Event{Time: time.Now(), Kind: EventAlert, Text: text})
+}
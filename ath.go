@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HistoryAPIURL is the API endpoint for daily historical prices
+const HistoryAPIURL = "https://min-api.cryptocompare.com/data/v2/histoday"
+
+var coinATHGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "coin_ath",
+	Help:      "All-time high price of a coin in the portfolio currency",
+}, []string{"coin"})
+
+var coinBelowATHGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "coin_below_ath_percent",
+	Help:      "How far a coin's price is below its all-time high, in percent",
+}, []string{"coin"})
+
+var portfolioATHGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "ath",
+	Help:      "Highest total portfolio value observed or in the snapshot history",
+})
+
+var portfolioBelowATHGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "below_ath_percent",
+	Help:      "How far the total portfolio value is below its observed high, in percent",
+})
+
+// coinATHs holds each coin's all-time high, seeded from provider history
+var coinATHs = map[string]float64{}
+
+// coinHistoryLoaded records which coins have had their history fetched
+var coinHistoryLoaded = map[string]bool{}
+
+// portfolioATH is the highest total observed, seeded from the snapshot
+// history once so it doesn't start over on every restart
+var portfolioATH = 0.0
+var portfolioATHSeeded = false
+
+// PrepareATHGauges registers the all-time high metrics
+func PrepareATHGauges() {
+	prometheus.Register(coinATHGauge)
+	prometheus.Register(coinBelowATHGauge)
+	prometheus.Register(portfolioATHGauge)
+	prometheus.Register(portfolioBelowATHGauge)
+}
+
+// UpdateATH raises the all-time highs to the latest valuation and exports the
+// distance from them. A coin's high is seeded from provider history the first
+// time it is seen, falling back to locally observed prices until that succeeds.
+func UpdateATH(ctx context.Context, config *Config, currency string) {
+	p := LoadPortfolio()
+	for _, value := range p.Coins {
+		if value.PricedAt.IsZero() {
+			continue
+		}
+		if !coinHistoryLoaded[value.Name] {
+			high, err := GetHistoricalHigh(ctx, ProviderSymbol(config, value.Name), currency)
+			if err != nil {
+				fmt.Println("History for", value.Name+":", err)
+			} else {
+				coinHistoryLoaded[value.Name] = true
+				if high > coinATHs[value.Name] {
+					coinATHs[value.Name] = high
+				}
+			}
+		}
+		if value.Price > coinATHs[value.Name] {
+			coinATHs[value.Name] = value.Price
+		}
+
+		symbol := strings.ToLower(value.Name)
+		coinATHGauge.WithLabelValues(symbol).Set(coinATHs[value.Name])
+		coinBelowATHGauge.WithLabelValues(symbol).Set(BelowPercent(value.Price, coinATHs[value.Name]))
+	}
+
+	if p.UpdatedAt.IsZero() {
+		return
+	}
+	if !portfolioATHSeeded {
+		snapshots, err := snapshotStore.Since(time.Time{})
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if watermark := SnapshotWatermark(snapshots, p.Currency); watermark.High > portfolioATH {
+			portfolioATH = watermark.High
+		}
+		portfolioATHSeeded = true
+	}
+	if p.Total > portfolioATH {
+		portfolioATH = p.Total
+	}
+	portfolioATHGauge.Set(portfolioATH)
+	portfolioBelowATHGauge.Set(BelowPercent(p.Total, portfolioATH))
+}
+
+// BelowPercent is how far a value is below a high, in percent
+func BelowPercent(value float64, high float64) float64 {
+	if high <= 0 {
+		return 0
+	}
+	return (high - value) / high * 100
+}
+
+// GetHistoricalHigh fetches a coin's highest daily price over its whole history
+func GetHistoricalHigh(ctx context.Context, symbol string, currency string) (float64, error) {
+	history, err := GetHistory(ctx, symbol, currency, url.Values{"allData": {"true"}})
+	if err != nil {
+		return 0, err
+	}
+	high := 0.0
+	for _, day := range history {
+		if day.High > high {
+			high = day.High
+		}
+	}
+	return high, nil
+}
+
+// GetHistory fetches daily prices for a coin, with params such as limit,
+// toTs or allData selecting the range
+func GetHistory(ctx context.Context, symbol string, currency string, params url.Values) ([]HistoryDay, error) {
+	u, err := url.Parse(HistoryAPIURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	for key, values := range params {
+		q[key] = values
+	}
+	q.Set("fsym", symbol)
+	q.Set("tsym", currency)
+	u.RawQuery = q.Encode()
+
+	result := HistoryAPIResponse{}
+	err = GetJSON(ctx, u.String(), &result)
+	if err != nil {
+		return nil, err
+	}
+	if result.Response == "Error" {
+		return nil, errors.New("History error: " + result.Message)
+	}
+	return result.Data.Data, nil
+}
+
+// HistoryAPIResponse is the JSON response from the daily history API
+type HistoryAPIResponse struct {
+	Response string `json:"Response"`
+	Message  string `json:"Message"`
+	Data     struct {
+		Data []HistoryDay `json:"Data"`
+	} `json:"Data"`
+}
+
+// HistoryDay is a day of prices from the daily history API
+type HistoryDay struct {
+	Time  int64   `json:"time"`
+	High  float64 `json:"high"`
+	Low   float64 `json:"low"`
+	Close float64 `json:"close"`
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Audit actions
+const (
+	AuditConfigLoad   = "config.load"
+	AuditConfigReload = "config.reload"
+)
+
+// AuditEntry is a runtime change recorded in the audit log
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Actor is who made the change, such as startup or a client address
+	Actor  string          `json:"actor"`
+	Action string          `json:"action"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// ConfigSummary is what the audit log records of a loaded config, leaving out
+// credentials
+type ConfigSummary struct {
+	SHA256   string            `json:"sha256"`
+	Currency string            `json:"currency"`
+	Coins    []AuditCoin       `json:"coins"`
+	Aliases  map[string]string `json:"aliases"`
+	Alerts   AuditAlerts       `json:"alerts"`
+}
+
+// AuditCoin is what the audit log records of a coin. Price sources are left
+// out since their RPC URLs often embed an API key.
+type AuditCoin struct {
+	Name   string
+	Amount float64
+	ID     string `json:",omitempty"`
+}
+
+// AuditAlerts is what the audit log records of the alert settings. The
+// webhook is left out since its URL is a credential.
+type AuditAlerts struct {
+	Milestones           []float64
+	ATH                  bool
+	ATHRearmPercent      float64
+	BalanceChanges       bool
+	BalanceChangePercent float64
+}
+
+var auditMu sync.Mutex
+
+// RecordAudit appends a change to the audit log. The log is only ever
+// appended to.
+func RecordAudit(config *Config, actor string, action string, before interface{}, after interface{}) error {
+	entry := AuditEntry{Time: time.Now(), Actor: actor, Action: action}
+	var err error
+	if before != nil {
+		entry.Before, err = json.Marshal(before)
+		if err != nil {
+			return err
+		}
+	}
+	if after != nil {
+		entry.After, err = json.Marshal(after)
+		if err != nil {
+			return err
+		}
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	f, err := os.OpenFile(config.Audit, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+// LoadAudit returns the audit entries recorded at or after a time, oldest first
+func LoadAudit(config *Config, since time.Time) ([]AuditEntry, error) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	entries := []AuditEntry{}
+	f, err := os.Open(config.Audit)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := AuditEntry{}
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Time.Before(since) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// SummariseConfig summarises a config for the audit log, hashing the raw
+// config it was parsed from
+func SummariseConfig(config *Config, raw []byte) *ConfigSummary {
+	sum := sha256.Sum256(raw)
+	coins := []AuditCoin{}
+	for _, coin := range LiveCoins(config) {
+		coins = append(coins, AuditCoin{Name: coin.Name, Amount: coin.Amount, ID: coin.ID})
+	}
+	alerts := LiveAlerts(config)
+	return &ConfigSummary{
+		SHA256:   hex.EncodeToString(sum[:]),
+		Currency: config.Currency,
+		Coins:    coins,
+		Aliases:  LiveAliases(config),
+		Alerts: AuditAlerts{
+			Milestones:           alerts.Milestones,
+			ATH:                  alerts.ATH,
+			ATHRearmPercent:      alerts.ATHRearmPercent,
+			BalanceChanges:       alerts.BalanceChanges,
+			BalanceChangePercent: alerts.BalanceChangePercent,
+		},
+	}
+}
+
+// GetAuditJSON returns the audit log as JSON, from the optional since
+// query parameter onwards
+func GetAuditJSON(config *Config) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		since := time.Time{}
+		if r.URL.Query().Get("since") != "" {
+			var err error
+			since, err = time.Parse(time.RFC3339, r.URL.Query().Get("since"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		entries, err := LoadAudit(config, since)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Could not read the audit log", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(entries)
+	}
+
+	return fn
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// historyPageSize is the most days the history API returns per request
+const historyPageSize = 2000
+
+// RunBackfill populates the snapshot store with end-of-day snapshots built from
+// historical daily closes and the configured amounts
+func RunBackfill(config *Config, args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	since := flags.String("since", "", "first day to backfill, as YYYY-MM-DD")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	start, err := time.Parse("2006-01-02", *since)
+	if err != nil {
+		return errors.New("Usage: backfill -since YYYY-MM-DD")
+	}
+	store, err := OpenSnapshotStore(config)
+	if err != nil {
+		return err
+	}
+
+	existing, err := store.Since(start)
+	if err != nil {
+		return err
+	}
+	// Days are keyed by their date in the configured timezone, as DailySeries
+	// reads them
+	covered := map[string]bool{}
+	for _, snapshot := range existing {
+		if snapshot.Daily {
+			covered[snapshot.Time.In(config.Location).Format("2006-01-02")] = true
+		}
+	}
+
+	coins := GetCoins(config)
+	days := map[int64]*Snapshot{}
+	for _, coin := range coins {
+		history, err := GetDailyHistory(context.Background(), ProviderSymbol(config, coin), config.Currency, start)
+		if err != nil {
+			return fmt.Errorf("%s: %v", coin, err)
+		}
+		amount := GetAmount(config, coin)
+		for _, day := range history {
+			snapshot, ok := days[day.Time]
+			if !ok {
+				snapshot = &Snapshot{
+					Time:     time.Unix(day.Time, 0).UTC().Add(24*time.Hour - time.Second),
+					Currency: config.Currency,
+					Values:   map[string]float64{},
+					Daily:    true,
+				}
+				days[day.Time] = snapshot
+			}
+			snapshot.Values[coin] = day.Close * amount
+			snapshot.Total = snapshot.Total + day.Close*amount
+		}
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+	added := 0
+	for t := start; t.Format("2006-01-02") < today; t = t.AddDate(0, 0, 1) {
+		snapshot, ok := days[t.Unix()]
+		if !ok || covered[snapshot.Time.In(config.Location).Format("2006-01-02")] {
+			continue
+		}
+		err = store.Append(*snapshot)
+		if err != nil {
+			return err
+		}
+		added++
+	}
+	fmt.Println("Backfilled", added, "days from", *since, "using the configured amounts")
+	return nil
+}
+
+// GetDailyHistory fetches daily prices for a coin from a day until today,
+// paging back through the history API
+func GetDailyHistory(ctx context.Context, symbol string, currency string, since time.Time) ([]HistoryDay, error) {
+	history := []HistoryDay{}
+	to := time.Now().Unix()
+	for to >= since.Unix() {
+		page, err := GetHistory(ctx, symbol, currency, url.Values{
+			"limit": {strconv.Itoa(historyPageSize)},
+			"toTs":  {strconv.FormatInt(to, 10)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, day := range page {
+			if day.Time >= since.Unix() && day.Close > 0 {
+				history = append(history, day)
+			}
+		}
+		to = page[0].Time - 1
+	}
+	return history, nil
+}
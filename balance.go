@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// lastRewards are the pending staking rewards included in the previous valuation
+var lastRewards = map[string]float64{}
+
+// DetectBalanceChanges notifies and records an event for each coin whose held
+// amount moved since the previous valuation, such as a deposit, withdrawal,
+// trade or a change in staked balance. Pending staking rewards grow every
+// block, so they are left out of the amounts compared.
+func DetectBalanceChanges(config *Config, previous map[string]CoinValue, p *Portfolio, rewards map[string]float64) {
+	last := lastRewards
+	lastRewards = rewards
+	alerts := LiveAlerts(config)
+	if !alerts.BalanceChanges {
+		return
+	}
+	for _, value := range p.Coins {
+		before, ok := previous[value.Name]
+		if !ok {
+			continue
+		}
+		from := before.Amount - last[value.Name]
+		to := value.Amount - rewards[value.Name]
+		delta := to - from
+		decimals := AmountDecimals(config, value.Name)
+		// Taking the rewards back out can leave float dust on an unchanged balance
+		if Round(config, delta, decimals) == 0 || math.Abs(delta) < math.Abs(from)*alerts.BalanceChangePercent/100 {
+			continue
+		}
+		sign := ""
+		if delta > 0 {
+			sign = "+"
+		}
+		text := value.Name + " balance changed by " + sign + FormatRounded(config, delta, decimals) +
+			" (" + FormatRounded(config, from, decimals) + " → " + FormatRounded(config, to, decimals) + ")"
+		Notify(config, text)
+		RecordEvent(config, Event{Time: time.Now(), Kind: EventBalance, Text: text, Coin: value.Name, Delta: delta})
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NotModified sets caching headers for a response that only changes when the
+// portfolio is updated, and answers with 304 Not Modified when the client
+// already has it. The ETag is derived from the update time plus a variant
+// for responses that differ by request, and is weak since the body may be
+// compressed.
+func NotModified(w http.ResponseWriter, r *http.Request, updatedAt time.Time, variant string) bool {
+	if updatedAt.IsZero() {
+		w.Header().Set("Cache-Control", "no-cache")
+		return false
+	}
+	etag := `W/"` + strconv.FormatInt(updatedAt.UnixNano(), 36)
+	if variant != "" {
+		etag = etag + "-" + variant
+	}
+	etag = etag + `"`
+
+	maxAge := int(time.Until(updatedAt.Add(UpdateInterval)).Seconds())
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(maxAge))
+
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimPrefix(strings.TrimSpace(match), "W/")
+		if "W/"+match == etag || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
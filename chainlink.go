@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// latestRoundDataSelector is the selector of latestRoundData() on a Chainlink aggregator
+	latestRoundDataSelector = "0xfeaf968c"
+	// decimalsSelector is the selector of decimals() on a Chainlink aggregator
+	decimalsSelector = "0x313ce567"
+)
+
+// ChainlinkConfig prices a coin from a Chainlink price feed
+type ChainlinkConfig struct {
+	// Feed is the address of the feed's proxy, e.g. the ETH / USD feed
+	Feed string `toml:"Feed"`
+	// RPC is the JSON-RPC endpoint of the feed's chain, defaulting to EthereumRPC
+	RPC string `toml:"RPC"`
+	// Quote is what the feed is denominated in, such as USD or ETH, priced by
+	// the provider unless it is the portfolio currency
+	Quote string `toml:"Quote"`
+	// MaxAge rejects answers older than this, such as a little over the feed's
+	// heartbeat, when set
+	MaxAge Duration `toml:"MaxAge"`
+}
+
+// chainlinkDecimals caches the decimals of each feed, which never change
+var chainlinkDecimals = map[string]int{}
+var chainlinkMu sync.Mutex
+
+// ParseChainlink checks the Chainlink sources and sets their defaults
+func ParseChainlink(config *Config) error {
+	for i := range config.Coins {
+		source := config.Coins[i].Chainlink
+		if source == nil {
+			continue
+		}
+		if config.Coins[i].Uniswap != nil {
+			return errors.New(config.Coins[i].Name + " can't be priced from both Uniswap and Chainlink")
+		}
+		if source.Feed == "" || source.Quote == "" {
+			return errors.New("Chainlink source of " + config.Coins[i].Name + " needs a Feed and a Quote")
+		}
+		if source.RPC == "" {
+			source.RPC = config.EthereumRPC
+		}
+	}
+	return nil
+}
+
+// GetChainlinkPrice values a coin in currency from its feed's latest answer
+// and the provider's price of the quote
+func GetChainlinkPrice(ctx context.Context, config *Config, prices PriceAPIResponse, source ChainlinkConfig, currency string) (float64, error) {
+	quotePrice, err := QuotePrice(config, prices, source.Quote, currency)
+	if err != nil {
+		return 0, err
+	}
+	answer, updatedAt, err := GetChainlinkAnswer(ctx, source.RPC, source.Feed)
+	if err != nil {
+		return 0, err
+	}
+	if source.MaxAge.Duration > 0 && time.Since(updatedAt) > source.MaxAge.Duration {
+		return 0, errors.New("Chainlink answer is stale, last updated " + updatedAt.Format(time.RFC3339))
+	}
+	return answer * quotePrice, nil
+}
+
+// GetChainlinkAnswer reads a feed's latest answer, scaled by its decimals, and
+// when it was updated
+func GetChainlinkAnswer(ctx context.Context, endpoint string, feed string) (float64, time.Time, error) {
+	decimals, err := GetChainlinkDecimals(ctx, endpoint, feed)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	// latestRoundData returns (roundId, answer, startedAt, updatedAt, answeredInRound)
+	round, err := EthCall(ctx, endpoint, feed, latestRoundDataSelector)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	answer, err := ABIInt(round, 1)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if answer.Sign() <= 0 {
+		return 0, time.Time{}, errors.New("Chainlink answer is not positive: " + answer.String())
+	}
+	updatedAt, err := ABIWord(round, 3)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return ScaleBig(answer, decimals), time.Unix(updatedAt.Int64(), 0), nil
+}
+
+// GetChainlinkDecimals returns the decimals of a feed's answers
+func GetChainlinkDecimals(ctx context.Context, endpoint string, feed string) (int, error) {
+	chainlinkMu.Lock()
+	decimals, ok := chainlinkDecimals[feed]
+	chainlinkMu.Unlock()
+	if ok {
+		return decimals, nil
+	}
+	result, err := EthCall(ctx, endpoint, feed, decimalsSelector)
+	if err != nil {
+		return 0, err
+	}
+	n, err := ABIWord(result, 0)
+	if err != nil {
+		return 0, err
+	}
+	if !n.IsInt64() || n.Int64() > 77 {
+		return 0, errors.New("Bad Chainlink decimals: " + n.String())
+	}
+	decimals = int(n.Int64())
+	chainlinkMu.Lock()
+	chainlinkDecimals[feed] = decimals
+	chainlinkMu.Unlock()
+	return decimals, nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ConfigURLEnv names the environment variable pointing at a remote config,
+// such as consul://localhost:8500/portfolio-metrics/config or
+// etcd://localhost:2379/portfolio-metrics/config. Append +https to the scheme
+// to connect over TLS.
+const ConfigURLEnv = "PORTFOLIO_CONFIG_URL"
+
+// ConsulTokenEnv is the ACL token sent to Consul, as the consul CLI reads it
+const ConsulTokenEnv = "CONSUL_HTTP_TOKEN"
+
+// ConfigPollInterval is how often etcd is checked for config changes
+const ConfigPollInterval = 30 * time.Second
+
+// ConsulWait is how long a Consul blocking query waits for a change
+const ConsulWait = 5 * time.Minute
+
+// ConfigSource is where the config is read from
+type ConfigSource interface {
+	// Read returns the raw config and a version that changes whenever it does
+	Read() ([]byte, string, error)
+	// Wait returns the config once it may have changed from version
+	Wait(version string) ([]byte, string, error)
+	// String names the source without credentials
+	String() string
+}
+
+// OpenConfigSource returns the remote source in PORTFOLIO_CONFIG_URL, or
+// config.toml when it isn't set
+func OpenConfigSource() (ConfigSource, error) {
+	raw := os.Getenv(ConfigURLEnv)
+	if raw == "" {
+		return &FileConfigSource{path: ConfigPath}, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	scheme := "http"
+	if strings.HasSuffix(u.Scheme, "+https") {
+		scheme = "https"
+	}
+	base := scheme + "://" + u.Host
+	switch strings.TrimSuffix(u.Scheme, "+https") {
+	case "consul":
+		return &ConsulConfigSource{base: base, key: strings.TrimPrefix(u.Path, "/"), token: os.Getenv(ConsulTokenEnv)}, nil
+	case "etcd":
+		return &EtcdConfigSource{base: base, key: u.Path}, nil
+	}
+	return nil, errors.New("Unknown config source: " + u.Scheme)
+}
+
+// FileConfigSource reads the config from a local file, which isn't watched
+type FileConfigSource struct {
+	path string
+}
+
+// Read returns the file
+func (s *FileConfigSource) Read() ([]byte, string, error) {
+	b, err := ioutil.ReadFile(s.path)
+	return b, "", err
+}
+
+// Wait never returns, since local files are only read at startup
+func (s *FileConfigSource) Wait(version string) ([]byte, string, error) {
+	select {}
+}
+
+func (s *FileConfigSource) String() string {
+	return s.path
+}
+
+// ConsulConfigSource reads the config from a Consul KV key, watching it with
+// blocking queries
+type ConsulConfigSource struct {
+	base  string
+	key   string
+	token string
+}
+
+// Read returns the value of the key and its modify index
+func (s *ConsulConfigSource) Read() ([]byte, string, error) {
+	return s.get(url.Values{"raw": {""}})
+}
+
+// Wait blocks until the key's modify index moves past version, or the wait times out
+func (s *ConsulConfigSource) Wait(version string) ([]byte, string, error) {
+	return s.get(url.Values{"raw": {""}, "index": {version}, "wait": {ConsulWait.String()}})
+}
+
+func (s *ConsulConfigSource) get(params url.Values) ([]byte, string, error) {
+	req, err := http.NewRequest("GET", s.base+"/v1/kv/"+s.key+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if s.token != "" {
+		req.Header.Set("X-Consul-Token", s.token)
+	}
+	client := &http.Client{Timeout: ConsulWait + time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return nil, "", errors.New("Bad status: " + resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return b, resp.Header.Get("X-Consul-Index"), nil
+}
+
+func (s *ConsulConfigSource) String() string {
+	return "consul:" + s.key
+}
+
+// EtcdConfigSource reads the config from an etcd key through the v3 JSON
+// gateway, polling for changes
+type EtcdConfigSource struct {
+	base string
+	key  string
+}
+
+// Read returns the value of the key and its mod revision
+func (s *EtcdConfigSource) Read() ([]byte, string, error) {
+	body, err := json.Marshal(map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(s.key))})
+	if err != nil {
+		return nil, "", err
+	}
+	req, err := http.NewRequest("POST", s.base+"/v3/kv/range", bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	result := struct {
+		Kvs []struct {
+			Value       string `json:"value"`
+			ModRevision string `json:"mod_revision"`
+		} `json:"kvs"`
+	}{}
+	err = DoJSON(req, &result)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(result.Kvs) == 0 {
+		return nil, "", errors.New("Config key not found in etcd: " + s.key)
+	}
+	b, err := base64.StdEncoding.DecodeString(result.Kvs[0].Value)
+	if err != nil {
+		return nil, "", err
+	}
+	return b, result.Kvs[0].ModRevision, nil
+}
+
+// Wait polls until the key's mod revision moves past version
+func (s *EtcdConfigSource) Wait(version string) ([]byte, string, error) {
+	for {
+		time.Sleep(ConfigPollInterval)
+		b, next, err := s.Read()
+		if err != nil || next != version {
+			return b, next, err
+		}
+	}
+}
+
+func (s *EtcdConfigSource) String() string {
+	return "etcd:" + s.key
+}
+
+// WatchConfig sends the raw config each time the source changes it
+func WatchConfig(source ConfigSource, version string) <-chan []byte {
+	changes := make(chan []byte)
+	go func() {
+		for {
+			b, next, err := source.Wait(version)
+			if err != nil {
+				fmt.Println("Watching config:", err)
+				time.Sleep(ConfigPollInterval)
+				continue
+			}
+			if next == version {
+				continue
+			}
+			version = next
+			changes <- b
+		}
+	}()
+	return changes
+}
+
+// liveConfigMu guards the coins, aliases and alerts of the config, which a
+// ConfigReloader replaces while the updater and handlers read them. They are
+// only ever replaced whole, so what the accessors below return is safe to
+// read without holding the lock.
+var liveConfigMu sync.RWMutex
+
+// LiveCoins returns the configured coins
+func LiveCoins(config *Config) []CoinConfig {
+	liveConfigMu.RLock()
+	defer liveConfigMu.RUnlock()
+	return config.Coins
+}
+
+// LiveAliases returns the configured aliases
+func LiveAliases(config *Config) map[string]string {
+	liveConfigMu.RLock()
+	defer liveConfigMu.RUnlock()
+	return config.Aliases
+}
+
+// LiveAlerts returns the alert settings
+func LiveAlerts(config *Config) AlertsConfig {
+	liveConfigMu.RLock()
+	defer liveConfigMu.RUnlock()
+	return config.Alerts
+}
+
+// ConfigReloader applies config changes to the running exporter. Coins,
+// aliases and alerts are applied live; anything else needs a restart.
+type ConfigReloader struct {
+	config  *Config
+	coins   []string
+	gauges  map[string]prometheus.Gauge
+	summary *ConfigSummary
+	actor   string
+}
+
+// Apply parses a changed config and applies what can be changed live,
+// recording the change in the audit log
+func (r *ConfigReloader) Apply(raw []byte) {
+	next, err := ParseConfig(raw)
+	if err != nil {
+		fmt.Println("Ignoring config change:", err)
+		return
+	}
+
+	liveConfigMu.Lock()
+	r.config.Coins = next.Coins
+	r.config.Aliases = next.Aliases
+	next.Alerts.State = r.config.Alerts.State
+	r.config.Alerts = next.Alerts
+	liveConfigMu.Unlock()
+
+	coins := GetCoins(r.config)
+	added := []string{}
+	kept := map[string]bool{}
+	for _, coin := range coins {
+		symbol := strings.ToLower(coin)
+		kept[symbol] = true
+		if _, ok := r.gauges[symbol]; !ok {
+			added = append(added, coin)
+		}
+	}
+	for symbol, gauge := range PrepareGauges(added, r.config.Currency) {
+		r.gauges[symbol] = gauge
+	}
+	for symbol, gauge := range r.gauges {
+		if !kept[symbol] {
+			prometheus.Unregister(gauge)
+			coinMissing.DeleteLabelValues(symbol)
+			delete(r.gauges, symbol)
+		}
+	}
+	RecordCoinChanges(r.config, coins)
+	r.coins = coins
+
+	summary := SummariseConfig(r.config, raw)
+	err = RecordAudit(r.config, r.actor, AuditConfigReload, r.summary, summary)
+	if err != nil {
+		fmt.Println(err)
+	}
+	r.summary = summary
+	fmt.Println("Applied config change from", r.actor)
+
+	next.Coins = r.config.Coins
+	next.Aliases = r.config.Aliases
+	next.Alerts = r.config.Alerts
+	next.Location = r.config.Location
+	if !reflect.DeepEqual(next, r.config) {
+		fmt.Println("Restart to apply config changes other than coins, aliases and alerts")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var currencyPattern = regexp.MustCompile(`^[A-Z0-9]{2,10}$`)
+
+// conversionRate is a rate fetched for the portfolio as of UpdatedAt
+type conversionRate struct {
+	UpdatedAt time.Time
+	Rate      float64
+}
+
+var (
+	conversionMu    sync.Mutex
+	conversionRates = map[string]conversionRate{}
+)
+
+// ParseCurrency normalises a currency from a request, returning an error if it
+// doesn't look like a ticker
+func ParseCurrency(currency string) (string, error) {
+	currency = strings.ToUpper(currency)
+	if !currencyPattern.MatchString(currency) {
+		return "", errors.New("Bad currency: " + currency)
+	}
+	return currency, nil
+}
+
+// GetConversionRate returns the price of one unit of from in to. Rates are
+// fetched on demand and reused until the portfolio next updates.
+func GetConversionRate(ctx context.Context, from, to string, updatedAt time.Time) (float64, error) {
+	key := from + "/" + to
+	conversionMu.Lock()
+	cached, ok := conversionRates[key]
+	conversionMu.Unlock()
+	if ok && cached.UpdatedAt.Equal(updatedAt) {
+		return cached.Rate, nil
+	}
+
+	prices, err := GetPrices(ctx, []string{from}, to)
+	if err != nil {
+		return 0, err
+	}
+	rate, ok := prices[from][to]
+	if !ok || rate <= 0 {
+		return 0, errors.New("No rate from " + from + " to " + to)
+	}
+
+	conversionMu.Lock()
+	conversionRates[key] = conversionRate{UpdatedAt: updatedAt, Rate: rate}
+	conversionMu.Unlock()
+	return rate, nil
+}
+
+// ConvertPortfolio returns a copy of p with every price and value converted to
+// currency at rate
+func ConvertPortfolio(p *Portfolio, currency string, rate float64) *Portfolio {
+	converted := *p
+	converted.Currency = currency
+	converted.Total = p.Total * rate
+	converted.IlliquidTotal = p.IlliquidTotal * rate
+
+	converted.Coins = make([]CoinValue, len(p.Coins))
+	for i, coin := range p.Coins {
+		coin.Price = coin.Price * rate
+		coin.Value = coin.Value * rate
+		converted.Coins[i] = coin
+	}
+	converted.Positions = make([]PositionValue, len(p.Positions))
+	for i, position := range p.Positions {
+		position.Value = position.Value * rate
+		position.HoldValue = position.HoldValue * rate
+		position.ImpermanentLoss = position.ImpermanentLoss * rate
+		converted.Positions[i] = position
+	}
+	converted.Illiquid = make([]NFTValue, len(p.Illiquid))
+	for i, nft := range p.Illiquid {
+		nft.FloorPrice = nft.FloorPrice * rate
+		nft.Value = nft.Value * rate
+		converted.Illiquid[i] = nft
+	}
+	return &converted
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var dailyChangeGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "daily_change",
+	Help:      "Change in total portfolio value since the last end-of-day snapshot",
+})
+
+var dailyChangePercentGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "daily_change_percent",
+	Help:      "Percent change in total portfolio value since the last end-of-day snapshot",
+})
+
+// PrepareDailyGauges registers the daily change metrics
+func PrepareDailyGauges() {
+	prometheus.Register(dailyChangeGauge)
+	prometheus.Register(dailyChangePercentGauge)
+}
+
+// ParseDailySnapshotTime parses the HH:MM the end-of-day snapshot is taken at
+func ParseDailySnapshotTime(value string) (int, int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, 0, errors.New("Bad DailySnapshotTime, expected HH:MM: " + value)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// NextDailySnapshot returns the next time after now the end-of-day snapshot is due
+func NextDailySnapshot(now time.Time, hour int, minute int, loc *time.Location) time.Time {
+	local := now.In(loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), hour, minute, 0, 0, loc)
+	if !next.After(now) {
+		next = time.Date(local.Year(), local.Month(), local.Day()+1, hour, minute, 0, 0, loc)
+	}
+	return next
+}
+
+// StartDailySnapshots takes an end-of-day snapshot at the configured local time
+func StartDailySnapshots(config *Config) {
+	if config.DailySnapshotTime == "" {
+		return
+	}
+	hour, minute, err := ParseDailySnapshotTime(config.DailySnapshotTime)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	go func() {
+		for {
+			next := NextDailySnapshot(time.Now(), hour, minute, config.Location)
+			time.Sleep(time.Until(next))
+			if elector != nil && !elector.IsLeader() {
+				continue
+			}
+			func() {
+				defer Recover("daily-snapshot")
+				err := TakeDailySnapshot(config, next)
+				if err != nil {
+					fmt.Println(err)
+				}
+			}()
+		}
+	}()
+}
+
+// TakeDailySnapshot stores the latest valuation as the end-of-day snapshot for a day
+func TakeDailySnapshot(config *Config, at time.Time) error {
+	p := LoadPortfolio()
+	if p.UpdatedAt.IsZero() {
+		return errors.New("No valuation for the end-of-day snapshot")
+	}
+	snapshot := NewSnapshot(p)
+	snapshot.Time = at
+	snapshot.Daily = true
+	fmt.Println("Taking end-of-day snapshot:", FormatFloat(snapshot.Total), snapshot.Currency)
+	err := snapshotStore.Append(snapshot)
+	if err != nil {
+		return err
+	}
+	ExportSnapshot(config, snapshot)
+	return nil
+}
+
+// DailySeries returns one snapshot per day, oldest first. Explicit end-of-day
+// snapshots are used when there are any, otherwise the last snapshot of each
+// day in the location.
+func DailySeries(snapshots []Snapshot, loc *time.Location) []Snapshot {
+	daily := []Snapshot{}
+	for _, snapshot := range snapshots {
+		if snapshot.Daily {
+			daily = append(daily, snapshot)
+		}
+	}
+	if len(daily) > 0 {
+		return daily
+	}
+	return DailyCloses(snapshots, loc)
+}
+
+// UpdateDailyChange exports the change since the last end-of-day snapshot
+func UpdateDailyChange() {
+	p := LoadPortfolio()
+	if p.UpdatedAt.IsZero() {
+		return
+	}
+	last, err := LatestDailySnapshot()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if last == nil {
+		return
+	}
+	dailyChangeGauge.Set(p.Total - last.Total)
+	if last.Total != 0 {
+		dailyChangePercentGauge.Set((p.Total/last.Total - 1) * 100)
+	}
+}
+
+// LatestDailySnapshot returns the most recent end-of-day snapshot in the last week, if any
+func LatestDailySnapshot() (*Snapshot, error) {
+	recent, err := RecentSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	snapshots := SnapshotsSince(recent, time.Now().Add(-7*24*time.Hour))
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].Daily {
+			return &snapshots[i], nil
+		}
+	}
+	return nil, nil
+}
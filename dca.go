@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Cadences of a DCA plan
+const (
+	CadenceDaily       = "daily"
+	CadenceWeekly      = "weekly"
+	CadenceFortnightly = "fortnightly"
+	CadenceMonthly     = "monthly"
+)
+
+// DCAProjectionHorizon is how far ahead cost basis is projected for plans
+// without an end date
+const DCAProjectionHorizon = 365 * 24 * time.Hour
+
+// DCAConfig is a recurring purchase planned for a coin
+type DCAConfig struct {
+	Coin string `toml:"Coin"`
+	// Amount is spent in the portfolio currency at each contribution
+	Amount float64 `toml:"Amount"`
+	// Cadence is daily, weekly, fortnightly or monthly
+	Cadence string `toml:"Cadence"`
+	// Start is the YYYY-MM-DD date of the first contribution, in Timezone
+	Start string `toml:"Start"`
+	// End is the YYYY-MM-DD date of the last contribution, the plan is open ended when empty
+	End string `toml:"End"`
+
+	start time.Time
+	end   time.Time
+}
+
+var dcaPlannedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "dca_planned_contributions",
+	Help:      "Amount planned to be spent on a coin so far in the portfolio currency",
+}, []string{"coin"})
+
+var dcaActualGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "dca_actual_contributions",
+	Help:      "Amount spent buying a coin in the ledger since its plan started, in the portfolio currency",
+}, []string{"coin"})
+
+var dcaNextGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "dca_next_contribution_timestamp_seconds",
+	Help:      "Unix time of a coin's next planned contribution",
+}, []string{"coin"})
+
+var dcaNextAmountGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "dca_next_contribution",
+	Help:      "Amount of a coin's next planned contribution in the portfolio currency",
+}, []string{"coin"})
+
+var dcaProjectedCostGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "dca_projected_cost_basis",
+	Help:      "Cost basis of a coin once the remaining planned contributions are made, up to a year ahead for open ended plans",
+}, []string{"coin"})
+
+var dcaProjectedAverageGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "dca_projected_average_cost",
+	Help:      "Average cost per coin once the remaining planned contributions are made at the current price",
+}, []string{"coin"})
+
+// ParseDCA checks the DCA plans and parses their dates in the configured timezone
+func ParseDCA(config *Config) error {
+	for i := range config.DCA {
+		plan := &config.DCA[i]
+		if plan.Coin == "" || plan.Amount <= 0 {
+			return errors.New("DCA plans need a Coin and a positive Amount")
+		}
+		switch plan.Cadence {
+		case CadenceDaily, CadenceWeekly, CadenceFortnightly, CadenceMonthly:
+		default:
+			return errors.New("Unknown DCA cadence for " + plan.Coin + ": " + plan.Cadence)
+		}
+		var err error
+		plan.start, err = time.ParseInLocation("2006-01-02", plan.Start, config.Location)
+		if err != nil {
+			return err
+		}
+		if plan.End != "" {
+			plan.end, err = time.ParseInLocation("2006-01-02", plan.End, config.Location)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PrepareDCAGauges registers the DCA metrics when plans are configured
+func PrepareDCAGauges(config *Config) {
+	if len(config.DCA) == 0 {
+		return
+	}
+	prometheus.Register(dcaPlannedGauge)
+	prometheus.Register(dcaActualGauge)
+	prometheus.Register(dcaNextGauge)
+	prometheus.Register(dcaNextAmountGauge)
+	prometheus.Register(dcaProjectedCostGauge)
+	prometheus.Register(dcaProjectedAverageGauge)
+}
+
+// Contribution returns the time of a plan's nth contribution, counting from 0
+func (plan DCAConfig) Contribution(n int) time.Time {
+	switch plan.Cadence {
+	case CadenceDaily:
+		return plan.start.AddDate(0, 0, n)
+	case CadenceWeekly:
+		return plan.start.AddDate(0, 0, 7*n)
+	case CadenceFortnightly:
+		return plan.start.AddDate(0, 0, 14*n)
+	}
+	// Monthly contributions fall on the last day of months shorter than the start day
+	month := time.Date(plan.start.Year(), plan.start.Month()+time.Month(n), 1, 0, 0, 0, 0, plan.start.Location())
+	day := plan.start.Day()
+	if last := month.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return month.AddDate(0, 0, day-1)
+}
+
+// Contributions counts a plan's contributions made by a time, returning the
+// time of the next one, which is zero once the plan has ended
+func (plan DCAConfig) Contributions(t time.Time) (int, time.Time) {
+	n := 0
+	for {
+		next := plan.Contribution(n)
+		if !plan.end.IsZero() && next.After(plan.end) {
+			return n, time.Time{}
+		}
+		if next.After(t) {
+			return n, next
+		}
+		n++
+	}
+}
+
+// UpdateDCA compares each coin's DCA plans with the buys in the ledger, and
+// projects its cost basis once the remaining contributions are made
+func UpdateDCA(config *Config, txs []Transaction, book *Book) {
+	if len(config.DCA) == 0 {
+		return
+	}
+	now := time.Now()
+	planned := map[string]float64{}
+	remaining := map[string]float64{}
+	next := map[string]time.Time{}
+	nextAmount := map[string]float64{}
+	for _, plan := range config.DCA {
+		made, at := plan.Contributions(now)
+		planned[plan.Coin] = planned[plan.Coin] + float64(made)*plan.Amount
+		horizon := now.Add(DCAProjectionHorizon)
+		if !plan.end.IsZero() {
+			horizon = plan.end
+		}
+		total, _ := plan.Contributions(horizon)
+		remaining[plan.Coin] = remaining[plan.Coin] + float64(total-made)*plan.Amount
+		if at.IsZero() {
+			continue
+		}
+		if previous, ok := next[plan.Coin]; !ok || at.Before(previous) {
+			next[plan.Coin] = at
+			nextAmount[plan.Coin] = 0
+		}
+		if at.Equal(next[plan.Coin]) {
+			nextAmount[plan.Coin] = nextAmount[plan.Coin] + plan.Amount
+		}
+	}
+
+	actual := map[string]float64{}
+	for _, plan := range config.DCA {
+		if _, ok := actual[plan.Coin]; ok {
+			continue
+		}
+		start := plan.start
+		for _, other := range config.DCA {
+			if other.Coin == plan.Coin && other.start.Before(start) {
+				start = other.start
+			}
+		}
+		actual[plan.Coin] = 0
+		for _, tx := range txs {
+			if tx.Type == TransactionBuy && tx.Coin == plan.Coin && !tx.Time.Before(start) {
+				actual[plan.Coin] = actual[plan.Coin] + tx.Value + tx.FeeValue
+			}
+		}
+	}
+
+	prices := map[string]float64{}
+	for _, value := range LoadPortfolio().Coins {
+		if !value.PricedAt.IsZero() {
+			prices[value.Name] = value.Price
+		}
+	}
+	holdings := book.Holdings()
+	for coin := range planned {
+		dcaPlannedGauge.WithLabelValues(coin).Set(planned[coin])
+		dcaActualGauge.WithLabelValues(coin).Set(actual[coin])
+		if at, ok := next[coin]; ok {
+			dcaNextGauge.WithLabelValues(coin).Set(float64(at.Unix()))
+			dcaNextAmountGauge.WithLabelValues(coin).Set(nextAmount[coin])
+		} else {
+			dcaNextGauge.DeleteLabelValues(coin)
+			dcaNextAmountGauge.DeleteLabelValues(coin)
+		}
+
+		holding, ok := holdings[coin]
+		if !ok {
+			holding = &Holding{}
+		}
+		cost := holding.CostBasis + remaining[coin]
+		dcaProjectedCostGauge.WithLabelValues(coin).Set(cost)
+		price, ok := prices[coin]
+		if !ok || price == 0 {
+			continue
+		}
+		amount := holding.Amount + remaining[coin]/price
+		if amount > 0 {
+			dcaProjectedAverageGauge.WithLabelValues(coin).Set(cost / amount)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+)
+
+// The age key is taken from the same variables sops reads, so one key serves
+// both formats
+const (
+	AgeKeyEnv     = "SOPS_AGE_KEY"
+	AgeKeyFileEnv = "SOPS_AGE_KEY_FILE"
+)
+
+// DecryptConfig returns the plaintext of a config that may be encrypted with
+// sops or age, decrypting with the sops and age command line tools. Plaintext
+// configs are returned unchanged.
+func DecryptConfig(b []byte) ([]byte, error) {
+	switch {
+	case IsAgeEncrypted(b):
+		return DecryptAge(b)
+	case IsSopsEncrypted(b):
+		return Decrypt(b, "sops", "--decrypt", "--input-type", "binary", "--output-type", "binary", "/dev/stdin")
+	}
+	return b, nil
+}
+
+// IsAgeEncrypted reports whether b is an age file, binary or armored
+func IsAgeEncrypted(b []byte) bool {
+	return bytes.HasPrefix(b, []byte("age-encryption.org/v1")) ||
+		bytes.HasPrefix(bytes.TrimSpace(b), []byte("-----BEGIN AGE ENCRYPTED FILE-----"))
+}
+
+// IsSopsEncrypted reports whether b is a file sops encrypted as binary, which
+// is how it stores formats it doesn't parse such as TOML
+func IsSopsEncrypted(b []byte) bool {
+	file := struct {
+		Data *string         `json:"data"`
+		Sops json.RawMessage `json:"sops"`
+	}{}
+	err := json.Unmarshal(b, &file)
+	return err == nil && file.Data != nil && len(file.Sops) > 0
+}
+
+// DecryptAge decrypts an age file with the identity in SOPS_AGE_KEY_FILE, or
+// SOPS_AGE_KEY when the key is in the environment
+func DecryptAge(b []byte) ([]byte, error) {
+	keyFile := os.Getenv(AgeKeyFileEnv)
+	if key := os.Getenv(AgeKeyEnv); key != "" {
+		f, err := ioutil.TempFile("", "age-key")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(f.Name())
+		_, err = f.WriteString(key + "\n")
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		keyFile = f.Name()
+	}
+	if keyFile == "" {
+		return nil, errors.New("Config is age encrypted but neither " + AgeKeyEnv + " nor " + AgeKeyFileEnv + " is set")
+	}
+	return Decrypt(b, "age", "--decrypt", "--identity", keyFile)
+}
+
+// Decrypt runs a decryption command on b and returns what it writes to stdout
+func Decrypt(b []byte, name string, args ...string) ([]byte, error) {
+	out, err := RunFilter(b, name, args...)
+	if err != nil {
+		return nil, errors.New("Could not decrypt config with " + name + ": " + err.Error())
+	}
+	return out, nil
+}
+
+// RunFilter runs a command with b on stdin and returns what it writes to
+// stdout, including its stderr in the error when it fails
+func RunFilter(b []byte, name string, args ...string) ([]byte, error) {
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.New(err.Error() + ": " + string(bytes.TrimSpace(stderr.Bytes())))
+	}
+	return out, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EmailConfig configures sending email through an SMTP server, which is
+// upgraded to TLS with STARTTLS when it offers it
+type EmailConfig struct {
+	Host string `toml:"Host"`
+	// Port defaults to 587
+	Port     int      `toml:"Port"`
+	Username string   `toml:"Username"`
+	Password string   `toml:"Password"`
+	From     string   `toml:"From"`
+	To       []string `toml:"To"`
+}
+
+// Attachment is a file attached to an email
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// SendEmail sends a plain text email with attachments to the configured recipients
+func SendEmail(config EmailConfig, subject string, body string, attachments ...Attachment) error {
+	if config.Host == "" || config.From == "" || len(config.To) == 0 {
+		return errors.New("Email needs a Host, From and To")
+	}
+	port := config.Port
+	if port == 0 {
+		port = 587
+	}
+	msg, err := BuildEmail(config.From, config.To, subject, body, attachments)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if config.Username != "" {
+		auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(config.Host, strconv.Itoa(port)), auth, config.From, config.To, msg)
+}
+
+// BuildEmail encodes a MIME message with a text body followed by the attachments
+func BuildEmail(from string, to []string, subject string, body string, attachments []Attachment) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	buf.WriteString("From: " + from + "\r\n")
+	buf.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	buf.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	buf.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: multipart/mixed; boundary=" + w.Boundary() + "\r\n\r\n")
+
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	qp := quotedprintable.NewWriter(part)
+	_, err = qp.Write([]byte(body))
+	if err != nil {
+		return nil, err
+	}
+	err = qp.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attachment := range attachments {
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded + "\r\n"))
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
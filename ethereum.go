@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RPCRequest is a JSON-RPC request to an Ethereum node
+type RPCRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+// RPCResponse is a JSON-RPC response from an Ethereum node
+type RPCResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// CallRPC makes a JSON-RPC request and decodes its result into result
+func CallRPC(ctx context.Context, endpoint string, method string, params []interface{}, result interface{}) error {
+	if endpoint == "" {
+		return errors.New("No RPC endpoint configured")
+	}
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(RPCRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+
+	// Only the host is traced, as node providers put the API key in the path
+	host := ""
+	if u, err := url.Parse(endpoint); err == nil {
+		host = u.Host
+	}
+	return TraceCall(ctx, method, host, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode > 299 {
+			return errors.New("Bad status: " + resp.Status)
+		}
+
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		response := RPCResponse{}
+		err = json.Unmarshal(b, &response)
+		if err != nil {
+			return err
+		}
+		if response.Error != nil {
+			return errors.New(method + ": " + response.Error.Message)
+		}
+
+		return json.Unmarshal(response.Result, result)
+	})
+}
+
+// EthCall calls a read-only contract method and returns the raw return data
+func EthCall(ctx context.Context, endpoint string, to string, data string) ([]byte, error) {
+	call := map[string]string{"to": to, "data": data}
+	result := ""
+	err := CallRPC(ctx, endpoint, "eth_call", []interface{}{call, "latest"}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimPrefix(result, "0x"))
+}
+
+// ABIWord returns the i-th 32 byte word of ABI encoded return data as an unsigned integer
+func ABIWord(data []byte, i int) (*big.Int, error) {
+	if len(data) < (i+1)*32 {
+		return nil, errors.New("Short ABI response")
+	}
+	return new(big.Int).SetBytes(data[i*32 : (i+1)*32]), nil
+}
+
+// ABIInt returns the i-th 32 byte word of ABI encoded return data as a signed integer
+func ABIInt(data []byte, i int) (*big.Int, error) {
+	n, err := ABIWord(data, i)
+	if err != nil {
+		return nil, err
+	}
+	if n.Bit(255) == 1 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), 256))
+	}
+	return n, nil
+}
+
+// ABIWordAddress returns the i-th 32 byte word of ABI encoded return data as an address
+func ABIWordAddress(data []byte, i int) (string, error) {
+	if len(data) < (i+1)*32 {
+		return "", errors.New("Short ABI response")
+	}
+	return "0x" + hex.EncodeToString(data[i*32+12:(i+1)*32]), nil
+}
+
+// ABIAddress encodes an address as a 32 byte ABI argument
+func ABIAddress(address string) string {
+	return strings.Repeat("0", 24) + strings.ToLower(strings.TrimPrefix(address, "0x"))
+}
+
+// ParseHexBig parses a 0x-prefixed hex quantity
+func ParseHexBig(hex string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(strings.TrimPrefix(hex, "0x"), 16)
+	if !ok {
+		return nil, errors.New("Bad hex quantity: " + hex)
+	}
+	return n, nil
+}
+
+// ScaleBig converts an integer amount with the given number of decimals to a float
+func ScaleBig(n *big.Int, decimals int) float64 {
+	f := new(big.Float).SetInt(n)
+	f.Quo(f, new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+	v, _ := f.Float64()
+	return v
+}
+
+// GetGasPrices fetches the latest block's base fee and the node's suggested gas price, in gwei
+func GetGasPrices(ctx context.Context, endpoint string) (float64, float64, error) {
+	block := struct {
+		BaseFeePerGas string `json:"baseFeePerGas"`
+	}{}
+	err := CallRPC(ctx, endpoint, "eth_getBlockByNumber", []interface{}{"latest", false}, &block)
+	if err != nil {
+		return 0, 0, err
+	}
+	baseFee, err := ParseHexBig(block.BaseFeePerGas)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	price := ""
+	err = CallRPC(ctx, endpoint, "eth_gasPrice", nil, &price)
+	if err != nil {
+		return 0, 0, err
+	}
+	gasPrice, err := ParseHexBig(price)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return ScaleBig(baseFee, 9), ScaleBig(gasPrice, 9), nil
+}
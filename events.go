@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of event
+const (
+	EventCoins   = "coins"
+	EventAlert   = "alert"
+	EventBalance = "balance"
+)
+
+// GrafanaConfig configures pushing events to Grafana as annotations
+type GrafanaConfig struct {
+	URL   string `toml:"URL"`
+	Token string `toml:"Token"`
+	// DashboardUID limits annotations to one dashboard, otherwise they are organisation wide
+	DashboardUID string `toml:"DashboardUID"`
+}
+
+// Event is a notable change worth marking on dashboards
+type Event struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	Text string    `json:"text"`
+	// Coin and Delta describe a balance change
+	Coin  string  `json:"coin,omitempty"`
+	Delta float64 `json:"delta,omitempty"`
+}
+
+// GrafanaAnnotation is the JSON request body of Grafana's annotations API
+type GrafanaAnnotation struct {
+	DashboardUID string   `json:"dashboardUID,omitempty"`
+	Time         int64    `json:"time"`
+	Tags         []string `json:"tags"`
+	Text         string   `json:"text"`
+}
+
+// grafanaClient bounds annotation posts, which run on the update path
+var grafanaClient = &http.Client{Timeout: 10 * time.Second}
+
+// RecordEvent stores an event in the history and pushes it to Grafana as an
+// annotation when configured
+func RecordEvent(config *Config, event Event) {
+	if snapshotStore != nil {
+		err := snapshotStore.AppendEvent(event)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+	if config.Grafana.URL == "" {
+		return
+	}
+	err := PostAnnotation(config.Grafana, event)
+	if err != nil {
+		fmt.Println("Grafana annotation:", err)
+	}
+}
+
+// PostAnnotation creates a Grafana annotation for an event
+func PostAnnotation(grafana GrafanaConfig, event Event) error {
+	body, err := json.Marshal(GrafanaAnnotation{
+		DashboardUID: grafana.DashboardUID,
+		Time:         event.Time.UnixNano() / int64(time.Millisecond),
+		Tags:         []string{"portfolio-metrics", event.Kind},
+		Text:         event.Text,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(grafana.URL, "/")+"/api/annotations", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if grafana.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+grafana.Token)
+	}
+	resp, err := grafanaClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return errors.New("Bad status: " + resp.Status)
+	}
+	return nil
+}
+
+// RecordCoinChanges records an event when the configured coins differ from
+// those in the latest snapshot, i.e. coins were added or removed since the last run
+func RecordCoinChanges(config *Config, coins []string) {
+	latest, err := snapshotStore.Latest()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if latest == nil {
+		return
+	}
+	configured := map[string]bool{}
+	for _, coin := range coins {
+		configured[coin] = true
+	}
+	pools := map[string]bool{}
+	for _, pool := range config.Pools {
+		pools[pool.Name] = true
+	}
+
+	added, removed := []string{}, []string{}
+	for _, coin := range coins {
+		if _, ok := latest.Values[coin]; !ok {
+			added = append(added, coin)
+		}
+	}
+	for coin := range latest.Values {
+		if !configured[coin] && !pools[coin] {
+			removed = append(removed, coin)
+		}
+	}
+	sort.Strings(removed)
+
+	changes := []string{}
+	if len(added) > 0 {
+		changes = append(changes, "added "+strings.Join(added, ", "))
+	}
+	if len(removed) > 0 {
+		changes = append(changes, "removed "+strings.Join(removed, ", "))
+	}
+	if len(changes) == 0 {
+		return
+	}
+	RecordEvent(config, Event{Time: time.Now(), Kind: EventCoins, Text: "Coins " + strings.Join(changes, "; ")})
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ExportFormat converts ledger transactions and balances to a tax tool's CSV layout
+type ExportFormat struct {
+	TransactionHeader []string
+	Transaction       func(tx Transaction, currency string) []string
+	BalanceHeader     []string
+	Balance           func(position *Position, at time.Time) []string
+}
+
+// ExportFormats are the formats the export subcommand can produce
+var ExportFormats = map[string]ExportFormat{
+	"koinly": {
+		TransactionHeader: []string{"Date", "Sent Amount", "Sent Currency", "Received Amount", "Received Currency", "Fee Amount", "Fee Currency", "Net Worth Amount", "Net Worth Currency", "Label", "Description", "TxHash"},
+		Transaction:       KoinlyTransaction,
+		BalanceHeader:     []string{"Date", "Wallet", "Currency", "Amount"},
+		Balance: func(position *Position, at time.Time) []string {
+			return []string{at.UTC().Format("2006-01-02 15:04:05 UTC"), position.Account, position.Coin, FormatFloat(position.Amount())}
+		},
+	},
+	"cointracking": {
+		TransactionHeader: []string{"Type", "Buy Amount", "Buy Currency", "Sell Amount", "Sell Currency", "Fee", "Fee Currency", "Exchange", "Trade-Group", "Comment", "Date"},
+		Transaction:       CoinTrackingTransaction,
+		BalanceHeader:     []string{"Exchange", "Currency", "Amount", "Date"},
+		Balance: func(position *Position, at time.Time) []string {
+			return []string{position.Account, position.Coin, FormatFloat(position.Amount()), at.UTC().Format("2006-01-02 15:04:05")}
+		},
+	},
+}
+
+// CoinTrackingIncomeTypes maps income kinds to CoinTracking transaction types
+var CoinTrackingIncomeTypes = map[string]string{
+	IncomeMining:  "Mining",
+	IncomeStaking: "Staking",
+	IncomeAirdrop: "Airdrop",
+}
+
+// RunExport writes the ledger's transactions and current balances in a tax tool's format
+func RunExport(config *Config, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "koinly", "format to export, koinly or cointracking")
+	dir := flags.String("out", ".", "directory to write the export files to")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	exporter, ok := ExportFormats[*format]
+	if !ok {
+		return errors.New("Unsupported export format: " + *format)
+	}
+
+	txs, err := LoadLedger(config.Ledger)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(txs, func(i, j int) bool {
+		return txs[i].Time.Before(txs[j].Time)
+	})
+
+	rows := [][]string{exporter.TransactionHeader}
+	for _, tx := range txs {
+		rows = append(rows, exporter.Transaction(tx, config.Currency))
+	}
+	transactions := filepath.Join(*dir, *format+"-transactions.csv")
+	err = WriteCSV(transactions, rows)
+	if err != nil {
+		return err
+	}
+
+	book := BuildBook(txs, config.LotMethod, config.TaxableOuts)
+	keys := []string{}
+	for key := range book.Positions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	now := time.Now()
+	rows = [][]string{exporter.BalanceHeader}
+	for _, key := range keys {
+		if book.Positions[key].Amount() == 0 {
+			continue
+		}
+		rows = append(rows, exporter.Balance(book.Positions[key], now))
+	}
+	balances := filepath.Join(*dir, *format+"-balances.csv")
+	err = WriteCSV(balances, rows)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Wrote", transactions, "and", balances)
+	return nil
+}
+
+// KoinlyTransaction converts a transaction to a row of Koinly's universal format
+func KoinlyTransaction(tx Transaction, currency string) []string {
+	sentAmount, sentCurrency, receivedAmount, receivedCurrency, label := "", "", "", "", ""
+	switch tx.Type {
+	case TransactionBuy:
+		sentAmount, sentCurrency = FormatFloat(tx.Value), currency
+		receivedAmount, receivedCurrency = FormatFloat(tx.Amount), tx.Coin
+	case TransactionSell:
+		sentAmount, sentCurrency = FormatFloat(tx.Amount), tx.Coin
+		receivedAmount, receivedCurrency = FormatFloat(tx.Value), currency
+	case TransactionIn:
+		receivedAmount, receivedCurrency = FormatFloat(tx.Amount), tx.Coin
+	case TransactionIncome:
+		receivedAmount, receivedCurrency = FormatFloat(tx.Amount), tx.Coin
+		label = tx.Label
+		if label == "" {
+			label = "income"
+		}
+	case TransactionOut:
+		sentAmount, sentCurrency = FormatFloat(tx.Amount), tx.Coin
+	case TransactionFee:
+		sentAmount, sentCurrency = FormatFloat(tx.Fee), tx.Coin
+		label = "cost"
+	}
+	feeAmount, feeCurrency := "", ""
+	if tx.Fee > 0 && tx.Type != TransactionFee {
+		feeAmount, feeCurrency = FormatFloat(tx.Fee), tx.Coin
+	}
+	worth := tx.Value
+	if tx.Type == TransactionFee {
+		worth = tx.FeeValue
+	}
+	return []string{
+		tx.Time.UTC().Format("2006-01-02 15:04:05 UTC"),
+		sentAmount, sentCurrency,
+		receivedAmount, receivedCurrency,
+		feeAmount, feeCurrency,
+		FormatFloat(worth), currency,
+		label,
+		tx.Account,
+		tx.ID,
+	}
+}
+
+// CoinTrackingTransaction converts a transaction to a row of CoinTracking's CSV import format
+func CoinTrackingTransaction(tx Transaction, currency string) []string {
+	kind, buyAmount, buyCurrency, sellAmount, sellCurrency := "", "", "", "", ""
+	switch tx.Type {
+	case TransactionBuy:
+		kind = "Trade"
+		buyAmount, buyCurrency = FormatFloat(tx.Amount), tx.Coin
+		sellAmount, sellCurrency = FormatFloat(tx.Value), currency
+	case TransactionSell:
+		kind = "Trade"
+		buyAmount, buyCurrency = FormatFloat(tx.Value), currency
+		sellAmount, sellCurrency = FormatFloat(tx.Amount), tx.Coin
+	case TransactionIn:
+		kind = "Deposit"
+		buyAmount, buyCurrency = FormatFloat(tx.Amount), tx.Coin
+	case TransactionIncome:
+		kind = "Income"
+		if label, ok := CoinTrackingIncomeTypes[tx.Label]; ok {
+			kind = label
+		}
+		buyAmount, buyCurrency = FormatFloat(tx.Amount), tx.Coin
+	case TransactionOut:
+		kind = "Withdrawal"
+		sellAmount, sellCurrency = FormatFloat(tx.Amount), tx.Coin
+	case TransactionFee:
+		kind = "Other Fee"
+		sellAmount, sellCurrency = FormatFloat(tx.Fee), tx.Coin
+	}
+	fee, feeCurrency := "", ""
+	if tx.Fee > 0 && tx.Type != TransactionFee {
+		fee, feeCurrency = FormatFloat(tx.Fee), tx.Coin
+	}
+	return []string{
+		kind,
+		buyAmount, buyCurrency,
+		sellAmount, sellCurrency,
+		fee, feeCurrency,
+		tx.Account,
+		"",
+		tx.ID,
+		tx.Time.UTC().Format("2006-01-02 15:04:05"),
+	}
+}
+
+// WriteCSV writes rows to a new CSV file
+func WriteCSV(path string, rows [][]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = WriteCSVTo(f, rows)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteCSVTo writes rows as CSV
+func WriteCSVTo(w io.Writer, rows [][]string) error {
+	return csv.NewWriter(w).WriteAll(rows)
+}
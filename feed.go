@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// FeedWindow is how far back the Atom feed reaches
+const FeedWindow = 30 * 24 * time.Hour
+
+// FeedTitles are the entry titles of each kind of event
+var FeedTitles = map[string]string{
+	EventAlert:   "Alert",
+	EventBalance: "Balance change",
+	EventCoins:   "Coins changed",
+}
+
+// AtomFeed is an Atom 1.0 feed document
+type AtomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  AtomPerson  `xml:"author"`
+	Link    AtomLink    `xml:"link"`
+	Entries []AtomEntry `xml:"entry"`
+}
+
+// AtomPerson is the author of a feed
+type AtomPerson struct {
+	Name string `xml:"name"`
+}
+
+// AtomLink links a feed to its own URL
+type AtomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+// AtomEntry is an entry in an Atom feed
+type AtomEntry struct {
+	ID       string       `xml:"id"`
+	Title    string       `xml:"title"`
+	Updated  string       `xml:"updated"`
+	Category AtomCategory `xml:"category"`
+	Content  string       `xml:"content"`
+}
+
+// AtomCategory tags an entry with the kind of event
+type AtomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// GetFeed serves the recent daily summaries, fired alerts, balance changes
+// and coin changes as an Atom feed, newest first
+func GetFeed(config *Config) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		since := time.Now().Add(-FeedWindow)
+		events, err := snapshotStore.Events(since)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Could not load events", http.StatusInternalServerError)
+			return
+		}
+		snapshots, err := snapshotStore.Since(since.Add(-24 * time.Hour))
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Could not load snapshots", http.StatusInternalServerError)
+			return
+		}
+		entries := append(EventEntries(events), DailyEntries(config, snapshots, since)...)
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Updated > entries[j].Updated
+		})
+
+		updated := time.Unix(0, 0).UTC()
+		if len(entries) > 0 {
+			updated, _ = time.Parse(time.RFC3339, entries[0].Updated)
+		}
+		if NotModified(w, r, updated, "") {
+			return
+		}
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		feed := AtomFeed{
+			ID:      "urn:portfolio-metrics:feed",
+			Title:   "Portfolio Metrics",
+			Updated: updated.Format(time.RFC3339),
+			Author:  AtomPerson{Name: "portfolio-metrics"},
+			Link:    AtomLink{Rel: "self", Href: scheme + "://" + r.Host + r.URL.Path},
+			Entries: entries,
+		}
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		encoder := xml.NewEncoder(w)
+		encoder.Indent("", "  ")
+		err = encoder.Encode(feed)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	return fn
+}
+
+// EventEntries turns recorded events into feed entries
+func EventEntries(events []Event) []AtomEntry {
+	entries := []AtomEntry{}
+	for _, event := range events {
+		title, ok := FeedTitles[event.Kind]
+		if !ok {
+			title = event.Kind
+		}
+		if event.Coin != "" {
+			title = title + ": " + event.Coin
+		}
+		entries = append(entries, AtomEntry{
+			ID:       fmt.Sprintf("urn:portfolio-metrics:%s:%d", event.Kind, event.Time.UnixNano()),
+			Title:    title,
+			Updated:  event.Time.UTC().Format(time.RFC3339),
+			Category: AtomCategory{Term: event.Kind},
+			Content:  event.Text,
+		})
+	}
+	return entries
+}
+
+// DailyEntries summarises each day's close since a time, with its change from
+// the day before
+func DailyEntries(config *Config, snapshots []Snapshot, since time.Time) []AtomEntry {
+	entries := []AtomEntry{}
+	days := DailySeries(snapshots, config.Location)
+	now := time.Now()
+	for i, day := range days {
+		// Today's last snapshot isn't a close until the day is over
+		if day.Time.Before(since) || (!day.Daily && SameDay(day.Time, now, config.Location)) {
+			continue
+		}
+		date := day.Time.In(config.Location).Format("2006-01-02")
+		text := "Closed at " + FormatValue(config, day.Total, day.Currency) + " " + day.Currency
+		if i > 0 && days[i-1].Currency == day.Currency && days[i-1].Total != 0 {
+			change := day.Total - days[i-1].Total
+			text = text + fmt.Sprintf(", %s (%+.2f%%) on the day", FormatValue(config, change, day.Currency), change/days[i-1].Total*100)
+		}
+		entries = append(entries, AtomEntry{
+			ID:       "urn:portfolio-metrics:daily:" + date,
+			Title:    "Daily summary for " + date,
+			Updated:  day.Time.UTC().Format(time.RFC3339),
+			Category: AtomCategory{Term: "daily"},
+			Content:  text,
+		})
+	}
+	return entries
+}
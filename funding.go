@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// BinanceFundingAPIURL is the API endpoint for Binance USD-M perpetual funding rates
+const BinanceFundingAPIURL = "https://fapi.binance.com/fapi/v1/premiumIndex"
+
+// BybitFundingAPIURL is the API endpoint for Bybit linear perpetual funding rates
+const BybitFundingAPIURL = "https://api.bybit.com/v5/market/tickers"
+
+// FundingConfig is a perpetual pair to export the funding rate for
+type FundingConfig struct {
+	Exchange string `toml:"Exchange"`
+	Symbol   string `toml:"Symbol"`
+}
+
+var fundingGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "funding_rate",
+	Help:      "Current funding rate of a perpetual pair, as a fraction per funding interval",
+}, []string{"exchange", "symbol"})
+
+// GetFundingRate fetches the current funding rate of a perpetual pair
+func GetFundingRate(ctx context.Context, pair FundingConfig) (float64, error) {
+	switch strings.ToLower(pair.Exchange) {
+	case "binance":
+		return GetBinanceFundingRate(ctx, pair.Symbol)
+	case "bybit":
+		return GetBybitFundingRate(ctx, pair.Symbol)
+	}
+	return 0, errors.New("Unsupported funding exchange: " + pair.Exchange)
+}
+
+// GetBinanceFundingRate fetches the funding rate of a Binance USD-M perpetual
+func GetBinanceFundingRate(ctx context.Context, symbol string) (float64, error) {
+	u, err := url.Parse(BinanceFundingAPIURL)
+	if err != nil {
+		return 0, err
+	}
+	q := u.Query()
+	q.Set("symbol", strings.ToUpper(symbol))
+	u.RawQuery = q.Encode()
+
+	result := BinanceFundingAPIResponse{}
+	err = GetJSON(ctx, u.String(), &result)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(result.LastFundingRate, 64)
+}
+
+// GetBybitFundingRate fetches the funding rate of a Bybit linear perpetual
+func GetBybitFundingRate(ctx context.Context, symbol string) (float64, error) {
+	u, err := url.Parse(BybitFundingAPIURL)
+	if err != nil {
+		return 0, err
+	}
+	q := u.Query()
+	q.Set("category", "linear")
+	q.Set("symbol", strings.ToUpper(symbol))
+	u.RawQuery = q.Encode()
+
+	result := BybitTickersAPIResponse{}
+	err = GetJSON(ctx, u.String(), &result)
+	if err != nil {
+		return 0, err
+	}
+	if result.RetCode != 0 {
+		return 0, errors.New("Bybit error: " + result.RetMsg)
+	}
+	if len(result.Result.List) == 0 {
+		return 0, errors.New("No Bybit ticker for " + symbol)
+	}
+	return strconv.ParseFloat(result.Result.List[0].FundingRate, 64)
+}
+
+// BinanceFundingAPIResponse is the JSON response from the Binance premium index API
+type BinanceFundingAPIResponse struct {
+	Symbol          string `json:"symbol"`
+	LastFundingRate string `json:"lastFundingRate"`
+}
+
+// BybitTickersAPIResponse is the JSON response from the Bybit tickers API
+type BybitTickersAPIResponse struct {
+	RetCode int    `json:"retCode"`
+	RetMsg  string `json:"retMsg"`
+	Result  struct {
+		List []struct {
+			Symbol      string `json:"symbol"`
+			FundingRate string `json:"fundingRate"`
+		} `json:"list"`
+	} `json:"result"`
+}
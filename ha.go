@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// DefaultHALockKey is the advisory lock replicas compete for unless configured
+const DefaultHALockKey = 7370616
+
+// HAConfig configures running several replicas against one Postgres database
+type HAConfig struct {
+	// Enabled elects a leader through an advisory lock in SnapshotDSN. Only the
+	// leader fetches from providers and sends notifications; followers serve
+	// the valuation and provider metrics the leader publishes.
+	Enabled bool `toml:"Enabled"`
+	// LockKey is the advisory lock key, so deployments can share a database
+	LockKey int64 `toml:"LockKey"`
+}
+
+// haSchema creates the table the leader publishes its latest state to
+const haSchema = `
+CREATE TABLE IF NOT EXISTS portfolio_state (
+	id INTEGER PRIMARY KEY,
+	state JSONB NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+`
+
+var leaderGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "leader",
+	Help:      "Set on the replica currently elected to fetch from providers",
+})
+
+// sharedGauges are only set on the leader, from provider fetches or its alert
+// state, so they are published for the followers to serve, keyed by name
+var sharedGauges = map[string]prometheus.Collector{
+	"fear_greed":             fearGreedGauge,
+	"dominance":              dominanceGauge,
+	"circulating_supply":     circulatingSupplyGauge,
+	"max_supply":             maxSupplyGauge,
+	"fdv":                    fdvGauge,
+	"gas_base_fee":           gasBaseFeeGauge,
+	"gas_price":              gasPriceGauge,
+	"funding_rate":           fundingGauge,
+	"pool_token":             poolTokenGauge,
+	"pool_value":             poolValueGauge,
+	"pool_impermanent_loss":  poolImpermanentLossGauge,
+	"lending_collateral":     lendingCollateralGauge,
+	"lending_debt":           lendingDebtGauge,
+	"lending_health_factor":  lendingHealthFactorGauge,
+	"nft_floor":              nftFloorGauge,
+	"nft_value":              nftValueGauge,
+	"illiquid_value":         illiquidValueGauge,
+	"staked":                 stakedGauge,
+	"staking_rewards":        stakingRewardsGauge,
+	"coin_ath":               coinATHGauge,
+	"coin_below_ath_percent": coinBelowATHGauge,
+	"ath":                    portfolioATHGauge,
+	"below_ath_percent":      portfolioBelowATHGauge,
+	"alert_active":           alertActiveGauge,
+	"alert_threshold":        alertThresholdGauge,
+	"alert_value":            alertValueGauge,
+	"alert_fired":            alertFiredGauge,
+}
+
+// SharedState is what the leader publishes: its latest valuation and the
+// values of the shared gauges
+type SharedState struct {
+	Portfolio *Portfolio               `json:"portfolio"`
+	Gauges    map[string][]GaugeSample `json:"gauges"`
+}
+
+// GaugeSample is the value of a gauge, or of one labelled child of a gauge vector
+type GaugeSample struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// Elector holds the leader lock on a dedicated connection, since advisory
+// locks belong to the session that took them
+type Elector struct {
+	db   *sql.DB
+	key  int64
+	mu   sync.Mutex
+	conn *sql.Conn
+}
+
+var elector *Elector
+
+// OpenElector connects to Postgres for leader election, returning nil when HA
+// is disabled
+func OpenElector(config *Config) (*Elector, error) {
+	if !config.HA.Enabled {
+		return nil, nil
+	}
+	if config.SnapshotDSN == "" {
+		return nil, errors.New("HA requires SnapshotDSN")
+	}
+	db, err := sql.Open("postgres", config.SnapshotDSN)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(haSchema)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	prometheus.Register(leaderGauge)
+	return &Elector{db: db, key: config.HA.LockKey}, nil
+}
+
+// Campaign takes the leader lock if it is free, or checks it is still held,
+// reporting whether this replica leads
+func (e *Elector) Campaign(ctx context.Context) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.conn != nil {
+		if e.conn.PingContext(ctx) == nil {
+			return true
+		}
+		fmt.Println("Lost leader lock")
+		e.conn.Close()
+		e.conn = nil
+		leaderGauge.Set(0)
+	}
+
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	acquired := false
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", e.key).Scan(&acquired)
+	if err != nil || !acquired {
+		if err != nil {
+			fmt.Println(err)
+		}
+		conn.Close()
+		return false
+	}
+	fmt.Println("Elected leader")
+	e.conn = conn
+	leaderGauge.Set(1)
+	return true
+}
+
+// IsLeader reports whether this replica held the lock at the last campaign
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.conn != nil
+}
+
+// Publish shares the leader's latest state with the followers
+func (e *Elector) Publish(state *SharedState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	_, err = e.db.Exec(
+		`INSERT INTO portfolio_state (id, state, updated_at) VALUES (1, $1, $2)
+		ON CONFLICT (id) DO UPDATE SET state = EXCLUDED.state, updated_at = EXCLUDED.updated_at`,
+		string(b), state.Portfolio.UpdatedAt,
+	)
+	return err
+}
+
+// Load returns the state last published by a leader, or nil when none has been
+func (e *Elector) Load() (*SharedState, error) {
+	b := []byte{}
+	err := e.db.QueryRow("SELECT state FROM portfolio_state WHERE id = 1").Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &SharedState{}
+	err = json.Unmarshal(b, state)
+	if err != nil {
+		return nil, err
+	}
+	if state.Portfolio == nil {
+		// Published by an older leader as the bare valuation
+		state.Portfolio = &Portfolio{}
+		err = json.Unmarshal(b, state.Portfolio)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// PublishState shares the latest valuation and shared gauges when HA is enabled
+func PublishState() error {
+	if elector == nil {
+		return nil
+	}
+	p := LoadPortfolio()
+	if p.UpdatedAt.IsZero() {
+		return nil
+	}
+	gauges, err := GatherSharedGauges()
+	if err != nil {
+		return err
+	}
+	return elector.Publish(&SharedState{Portfolio: p, Gauges: gauges})
+}
+
+// GatherSharedGauges reads the current value of every shared gauge
+func GatherSharedGauges() (map[string][]GaugeSample, error) {
+	gauges := map[string][]GaugeSample{}
+	for name, collector := range sharedGauges {
+		metrics := make(chan prometheus.Metric)
+		go func() {
+			collector.Collect(metrics)
+			close(metrics)
+		}()
+		samples := []GaugeSample{}
+		var err error
+		for metric := range metrics {
+			m := &dto.Metric{}
+			if err == nil {
+				err = metric.Write(m)
+			}
+			if err != nil || m.Gauge == nil {
+				continue
+			}
+			sample := GaugeSample{Value: m.Gauge.GetValue()}
+			if len(m.Label) > 0 {
+				sample.Labels = map[string]string{}
+				for _, label := range m.Label {
+					sample.Labels[label.GetName()] = label.GetValue()
+				}
+			}
+			samples = append(samples, sample)
+		}
+		if err != nil {
+			return nil, err
+		}
+		gauges[name] = samples
+	}
+	return gauges, nil
+}
+
+// SetSharedGauges sets the shared gauges to the values a leader published
+func SetSharedGauges(gauges map[string][]GaugeSample) {
+	for name, samples := range gauges {
+		for _, sample := range samples {
+			switch gauge := sharedGauges[name].(type) {
+			case *prometheus.GaugeVec:
+				gauge.With(sample.Labels).Set(sample.Value)
+			case prometheus.Gauge:
+				gauge.Set(sample.Value)
+			}
+		}
+	}
+}
+
+// IsFollower reports whether HA is enabled and another replica leads
+func IsFollower(ctx context.Context) bool {
+	return elector != nil && !elector.Campaign(ctx)
+}
+
+// Follow serves the valuation and shared gauges published by the leader,
+// updating only what is derived from them without fetching from providers
+func Follow(ctx context.Context, config *Config, gauges map[string]prometheus.Gauge) {
+	err := TraceStage(ctx, "follow", func(ctx context.Context) error {
+		state, err := elector.Load()
+		if err != nil || state == nil {
+			return err
+		}
+		p := state.Portfolio
+		if !p.UpdatedAt.After(LoadPortfolio().UpdatedAt) {
+			return nil
+		}
+		portfolio.Store(p)
+		SetCoinGauges(gauges, p)
+		SetSharedGauges(state.Gauges)
+		SetReady()
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	TraceStage(ctx, "watermarks", func(ctx context.Context) error {
+		UpdateWatermarks(config)
+		return nil
+	})
+	TraceStage(ctx, "risk", func(ctx context.Context) error {
+		UpdateRisk(config)
+		return nil
+	})
+	TraceStage(ctx, "daily", func(ctx context.Context) error {
+		UpdateDailyChange()
+		return nil
+	})
+	TraceStage(ctx, "ledger", func(ctx context.Context) error {
+		UpdateLedger(config)
+		return nil
+	})
+}
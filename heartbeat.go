@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HeartbeatConfig configures pinging a dead man's switch such as
+// healthchecks.io, which alerts when the pings stop
+type HeartbeatConfig struct {
+	// URL is requested after every successful update cycle
+	URL string `toml:"URL"`
+	// FailURL is requested when the prices could not be fetched, e.g. the
+	// healthchecks.io URL with /fail appended
+	FailURL string `toml:"FailURL"`
+}
+
+var heartbeatClient = &http.Client{Timeout: 10 * time.Second}
+
+// Heartbeat pings the heartbeat URL after a successful update cycle, or the
+// failure URL after a failed one
+func Heartbeat(config *Config, err error) {
+	u := config.Heartbeat.URL
+	if err != nil {
+		u = config.Heartbeat.FailURL
+	}
+	if u == "" {
+		return
+	}
+	pingErr := Ping(u)
+	if pingErr != nil {
+		fmt.Println("Heartbeat:", pingErr)
+	}
+}
+
+// Ping requests a URL, ignoring the body
+func Ping(u string) error {
+	resp, err := heartbeatClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return errors.New("Bad status: " + resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ImportFormats are the export formats the import subcommand understands
+var ImportFormats = map[string]func(io.Reader, string) ([]Transaction, error){
+	"ledger-live": ParseLedgerLive,
+}
+
+// RunImport reads a wallet export, appends its new transactions to the ledger
+// and prints the resulting holdings and cost basis per account
+func RunImport(config *Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "ledger-live", "format of the file being imported")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("Usage: import [-format ledger-live] <file>")
+	}
+	parse, ok := ImportFormats[*format]
+	if !ok {
+		return errors.New("Unsupported import format: " + *format)
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	imported, err := parse(f, config.Currency)
+	if err != nil {
+		return err
+	}
+
+	txs, err := LoadLedger(config.Ledger)
+	if err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	for _, tx := range txs {
+		known[tx.ID] = true
+	}
+	added := []Transaction{}
+	for _, tx := range imported {
+		if known[tx.ID] {
+			continue
+		}
+		known[tx.ID] = true
+		added = append(added, tx)
+	}
+	err = AppendLedger(config.Ledger, added)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Imported", len(added), "new transactions,", len(imported)-len(added), "already in", config.Ledger)
+
+	book := BuildBook(append(txs, added...), config.LotMethod, config.TaxableOuts)
+	PrintHoldings(book)
+	PrintShortfalls(book)
+	return nil
+}
+
+// PrintHoldings prints the amount and cost basis of every open position
+func PrintHoldings(book *Book) {
+	keys := []string{}
+	for key := range book.Positions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tCOIN\tAMOUNT\tCOST BASIS")
+	for _, key := range keys {
+		position := book.Positions[key]
+		if position.Amount() == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.2f\n", position.Account, position.Coin, FormatFloat(position.Amount()), position.CostBasis())
+	}
+	w.Flush()
+}
+
+// PrintShortfalls warns of every send, sale or fee larger than the open lots
+// it was matched against, whose cost basis is missing
+func PrintShortfalls(book *Book) {
+	for _, shortfall := range book.Shortfalls {
+		fmt.Println("Warning:", shortfall.Account, "spent", FormatFloat(shortfall.Amount), shortfall.Coin,
+			"more than it held on", shortfall.Time.UTC().Format(time.RFC3339)+", is a transaction missing from the ledger?")
+	}
+}
+
+// ParseLedgerLive parses a Ledger Live operations export. Countervalues are
+// taken as cost basis, so the export should use the portfolio currency.
+func ParseLedgerLive(r io.Reader, currency string) ([]Transaction, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return []Transaction{}, nil
+	}
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"Operation Date", "Currency Ticker", "Operation Type", "Operation Amount", "Operation Fees", "Operation Hash", "Account Name"} {
+		if _, ok := columns[name]; !ok {
+			return nil, errors.New("Ledger Live export is missing column " + name)
+		}
+	}
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	number := func(record []string, name string) (float64, error) {
+		value := field(record, name)
+		if value == "" {
+			return 0, nil
+		}
+		return strconv.ParseFloat(value, 64)
+	}
+
+	txs := []Transaction{}
+	warned := false
+	for line, record := range records[1:] {
+		if status := field(record, "Status"); status != "" && status != "Confirmed" {
+			continue
+		}
+		if ticker := field(record, "Countervalue Ticker"); ticker != "" && !strings.EqualFold(ticker, currency) && !warned {
+			fmt.Println("Warning: countervalues are in", ticker, "but the portfolio currency is", currency)
+			warned = true
+		}
+		t, err := time.Parse(time.RFC3339, field(record, "Operation Date"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line+2, err)
+		}
+		amount, err := number(record, "Operation Amount")
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line+2, err)
+		}
+		fee, err := number(record, "Operation Fees")
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line+2, err)
+		}
+		countervalue, err := number(record, "Countervalue at Operation Date")
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line+2, err)
+		}
+
+		opType := field(record, "Operation Type")
+		tx := Transaction{
+			Time:    t,
+			Coin:    strings.ToUpper(field(record, "Currency Ticker")),
+			Amount:  amount,
+			Value:   countervalue,
+			Fee:     fee,
+			Account: field(record, "Account Name"),
+			ID:      "ledger-live:" + field(record, "Operation Hash") + ":" + field(record, "Currency Ticker") + ":" + opType,
+		}
+		if amount > 0 {
+			tx.FeeValue = fee * countervalue / amount
+		}
+		switch opType {
+		case "IN":
+			tx.Type = TransactionIn
+			tx.Fee = 0
+			tx.FeeValue = 0
+		case "REWARD":
+			tx.Type = TransactionIncome
+			tx.Label = IncomeStaking
+			tx.Fee = 0
+			tx.FeeValue = 0
+		case "OUT":
+			tx.Type = TransactionOut
+		default:
+			if fee == 0 {
+				continue
+			}
+			tx.Type = TransactionFee
+			tx.Amount = 0
+			tx.Value = 0
+		}
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
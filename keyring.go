@@ -0,0 +1,23 @@
+package main
+
+import (
+	"errors"
+
+	keyring "github.com/zalando/go-keyring"
+)
+
+// KeyringService is the service secrets are stored under in the OS keyring:
+// the macOS Keychain, Secret Service on Linux or the Windows Credential Manager
+const KeyringService = "portfolio-metrics"
+
+// GetKeyringSecret returns the secret stored in the OS keyring under name
+func GetKeyringSecret(name string) (string, error) {
+	secret, err := keyring.Get(KeyringService, name)
+	if err == keyring.ErrNotFound {
+		return "", errors.New("Secret not found in keyring: " + name)
+	}
+	if err != nil {
+		return "", errors.New("Could not read " + name + " from keyring: " + err.Error())
+	}
+	return secret, nil
+}
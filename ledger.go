@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Transaction types recorded in the ledger
+const (
+	TransactionBuy  = "buy"
+	TransactionSell = "sell"
+	TransactionIn   = "in"
+	TransactionOut  = "out"
+	TransactionFee  = "fee"
+	// TransactionIncome is coins received as income, with its kind in the label
+	TransactionIncome = "income"
+)
+
+// Kinds of income recorded as the label of income transactions
+const (
+	IncomeMining  = "mining"
+	IncomeStaking = "staking"
+	IncomeAirdrop = "airdrop"
+)
+
+// LedgerHeader is the header row of the ledger file
+var LedgerHeader = []string{"time", "type", "coin", "amount", "value", "fee", "fee_value", "account", "id", "label"}
+
+// ledgerRequiredFields is the number of fields in ledgers written before the label was added
+const ledgerRequiredFields = 9
+
+// Transaction is an entry in the transaction ledger. Amounts are always
+// positive, Value and FeeValue are in the portfolio currency at the time of
+// the transaction, and Fee is paid in the transaction's coin.
+type Transaction struct {
+	Time     time.Time
+	Type     string
+	Coin     string
+	Amount   float64
+	Value    float64
+	Fee      float64
+	FeeValue float64
+	Account  string
+	// ID identifies the transaction at its source so imports can be repeated
+	ID string
+	// Label qualifies the type, such as the kind of income
+	Label string
+}
+
+// Acquires reports whether the transaction adds to the holdings
+func (tx Transaction) Acquires() bool {
+	return tx.Type == TransactionBuy || tx.Type == TransactionIn || tx.Type == TransactionIncome
+}
+
+// Disposes reports whether the transaction removes from the holdings
+func (tx Transaction) Disposes() bool {
+	return tx.Type == TransactionSell || tx.Type == TransactionOut
+}
+
+// LoadLedger reads every transaction from the ledger file, which may not exist yet
+func LoadLedger(path string) ([]Transaction, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return []Transaction{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	txs := []Transaction{}
+	for line := 0; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 0 {
+			continue
+		}
+		if len(record) < ledgerRequiredFields {
+			return nil, errors.New(path + ": line " + strconv.Itoa(line+1) + ": too few fields")
+		}
+		tx, err := ParseLedgerRecord(record)
+		if err != nil {
+			return nil, errors.New(path + ": line " + strconv.Itoa(line+1) + ": " + err.Error())
+		}
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
+
+// ParseLedgerRecord parses a row of the ledger file
+func ParseLedgerRecord(record []string) (Transaction, error) {
+	tx := Transaction{Type: record[1], Coin: record[2], Account: record[7], ID: record[8]}
+	if len(record) > ledgerRequiredFields {
+		tx.Label = record[9]
+	}
+	t, err := time.Parse(time.RFC3339, record[0])
+	if err != nil {
+		return tx, err
+	}
+	tx.Time = t
+	floats := []*float64{&tx.Amount, &tx.Value, &tx.Fee, &tx.FeeValue}
+	for i, f := range floats {
+		if record[3+i] == "" {
+			continue
+		}
+		*f, err = strconv.ParseFloat(record[3+i], 64)
+		if err != nil {
+			return tx, err
+		}
+	}
+	return tx, nil
+}
+
+// AppendLedger appends transactions to the ledger file, creating it if needed
+func AppendLedger(path string, txs []Transaction) error {
+	_, err := os.Stat(path)
+	exists := err == nil
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if !exists {
+		w.Write(LedgerHeader)
+	}
+	for _, tx := range txs {
+		w.Write([]string{
+			tx.Time.UTC().Format(time.RFC3339),
+			tx.Type,
+			tx.Coin,
+			FormatFloat(tx.Amount),
+			FormatFloat(tx.Value),
+			FormatFloat(tx.Fee),
+			FormatFloat(tx.FeeValue),
+			tx.Account,
+			tx.ID,
+			tx.Label,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// FormatFloat formats a float with as many digits as needed to round trip
+func FormatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LedgerCollector exports cumulative figures derived from the transaction ledger
+type LedgerCollector struct {
+	book *atomic.Value
+
+	venueFees *prometheus.Desc
+	totalFees *prometheus.Desc
+	income    *prometheus.Desc
+
+	coinRealized    *prometheus.Desc
+	coinUnrealized  *prometheus.Desc
+	coinAverageCost *prometheus.Desc
+	coinBreakEven   *prometheus.Desc
+	realized        *prometheus.Desc
+	unrealized      *prometheus.Desc
+	breakEven       *prometheus.Desc
+}
+
+var ledgerCollector = &LedgerCollector{
+	book: &atomic.Value{},
+	venueFees: prometheus.NewDesc(
+		"portfolio_metrics_venue_fees_paid_total",
+		"Cumulative trading and network fees paid at a venue in the portfolio currency",
+		[]string{"venue"}, nil,
+	),
+	totalFees: prometheus.NewDesc(
+		"portfolio_metrics_fees_paid_total",
+		"Cumulative trading and network fees paid in the portfolio currency",
+		nil, nil,
+	),
+	income: prometheus.NewDesc(
+		"portfolio_metrics_income_total",
+		"Cumulative income received, valued in the portfolio currency at receipt",
+		[]string{"kind", "coin"}, nil,
+	),
+	coinRealized: prometheus.NewDesc(
+		"portfolio_metrics_coin_realized_pnl",
+		"Realized profit and loss of a coin's closed lots in the portfolio currency",
+		[]string{"coin"}, nil,
+	),
+	coinUnrealized: prometheus.NewDesc(
+		"portfolio_metrics_coin_unrealized_pnl",
+		"Unrealized profit and loss of a coin's open lots at the current price",
+		[]string{"coin"}, nil,
+	),
+	coinAverageCost: prometheus.NewDesc(
+		"portfolio_metrics_coin_average_cost",
+		"Average cost per coin held, pooling every acquisition including fees and reducing the pool proportionally on disposals, in the portfolio currency",
+		[]string{"coin"}, nil,
+	),
+	coinBreakEven: prometheus.NewDesc(
+		"portfolio_metrics_coin_break_even_price",
+		"Price a coin's holdings must sell at for its cost, fees and realized P&L to net to zero, 0 once realized gains cover the cost",
+		[]string{"coin"}, nil,
+	),
+	realized: prometheus.NewDesc(
+		"portfolio_metrics_realized_pnl",
+		"Realized profit and loss of all closed lots in the portfolio currency",
+		nil, nil,
+	),
+	unrealized: prometheus.NewDesc(
+		"portfolio_metrics_unrealized_pnl",
+		"Unrealized profit and loss of all open lots at current prices",
+		nil, nil,
+	),
+	breakEven: prometheus.NewDesc(
+		"portfolio_metrics_break_even_value",
+		"Value the holdings must sell for to recover their cost net of realized P&L across every coin, in the portfolio currency",
+		nil, nil,
+	),
+}
+
+// PrepareLedgerMetrics registers the ledger collector
+func PrepareLedgerMetrics() {
+	prometheus.Register(ledgerCollector)
+}
+
+// UpdateLedger reloads the ledger so its metrics, and the DCA plans tracked
+// against it, reflect newly imported transactions
+func UpdateLedger(config *Config) {
+	txs, err := LoadLedger(config.Ledger)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	book := BuildBook(txs, config.LotMethod, config.TaxableOuts)
+	// Only warn when the ledger changes rather than on every update
+	previous, ok := ledgerCollector.book.Load().(*Book)
+	if !ok || len(previous.Shortfalls) != len(book.Shortfalls) {
+		PrintShortfalls(book)
+	}
+	ledgerCollector.book.Store(book)
+	UpdateDCA(config, txs, book)
+}
+
+// Describe implements prometheus.Collector
+func (c *LedgerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.venueFees
+	ch <- c.totalFees
+	ch <- c.income
+	ch <- c.coinRealized
+	ch <- c.coinUnrealized
+	ch <- c.coinAverageCost
+	ch <- c.coinBreakEven
+	ch <- c.realized
+	ch <- c.unrealized
+	ch <- c.breakEven
+}
+
+// Collect implements prometheus.Collector
+func (c *LedgerCollector) Collect(ch chan<- prometheus.Metric) {
+	book, ok := c.book.Load().(*Book)
+	if !ok || len(book.Positions) == 0 {
+		return
+	}
+
+	venues := map[string]float64{}
+	total := 0.0
+	for _, fee := range book.Fees {
+		venues[fee.Account] = venues[fee.Account] + fee.Value
+		total = total + fee.Value
+	}
+	for venue, fees := range venues {
+		ch <- prometheus.MustNewConstMetric(c.venueFees, prometheus.CounterValue, fees, venue)
+	}
+	ch <- prometheus.MustNewConstMetric(c.totalFees, prometheus.CounterValue, total)
+
+	for key, income := range book.Income {
+		ch <- prometheus.MustNewConstMetric(c.income, prometheus.CounterValue, income, key.Kind, key.Coin)
+	}
+
+	prices := map[string]float64{}
+	for _, value := range LoadPortfolio().Coins {
+		if !value.PricedAt.IsZero() {
+			prices[value.Name] = value.Price
+		}
+	}
+	realized, unrealized, cost := 0.0, 0.0, 0.0
+	for coin, holding := range book.Holdings() {
+		realized = realized + holding.Realized
+		cost = cost + holding.CostBasis
+		ch <- prometheus.MustNewConstMetric(c.coinRealized, prometheus.GaugeValue, holding.Realized, coin)
+		if holding.Amount > 0 {
+			ch <- prometheus.MustNewConstMetric(c.coinAverageCost, prometheus.GaugeValue, holding.AverageCost, coin)
+			breakEven := math.Max(0, (holding.CostBasis-holding.Realized)/holding.Amount)
+			ch <- prometheus.MustNewConstMetric(c.coinBreakEven, prometheus.GaugeValue, breakEven, coin)
+		}
+		price, ok := prices[coin]
+		if !ok {
+			continue
+		}
+		pnl := holding.Amount*price - holding.CostBasis
+		unrealized = unrealized + pnl
+		ch <- prometheus.MustNewConstMetric(c.coinUnrealized, prometheus.GaugeValue, pnl, coin)
+	}
+	ch <- prometheus.MustNewConstMetric(c.realized, prometheus.GaugeValue, realized)
+	ch <- prometheus.MustNewConstMetric(c.unrealized, prometheus.GaugeValue, unrealized)
+	ch <- prometheus.MustNewConstMetric(c.breakEven, prometheus.GaugeValue, math.Max(0, cost-realized))
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// getUserAccountDataSelector is the selector of getUserAccountData(address) on an Aave v3 Pool
+	getUserAccountDataSelector = "0xbf92857c"
+	// aaveBaseDecimals is the precision of the USD base currency Aave v3 reports in
+	aaveBaseDecimals = 8
+
+	// Selectors of the Compound v3 Comet functions a position is read with
+	numAssetsSelector           = "0xa46fe83b"
+	getAssetInfoSelector        = "0xc8c7fe6b"
+	collateralBalanceOfSelector = "0x5c2549ee"
+	borrowBalanceOfSelector     = "0x374c49b4"
+	baseTokenPriceFeedSelector  = "0xe7dad6bd"
+	baseScaleSelector           = "0x44c1e5eb"
+	getPriceSelector            = "0x41976e09"
+	// cometPriceDecimals is the precision of the USD prices Comet reports
+	cometPriceDecimals = 8
+)
+
+// LendingConfig is an address whose lending protocol position is tracked
+type LendingConfig struct {
+	Name string `toml:"Name"`
+	// Protocol is the lending protocol, "aave" (v3) or "compound" (v3)
+	Protocol string `toml:"Protocol"`
+	// Pool is the Aave pool contract address, or the Compound Comet market address
+	Pool    string `toml:"Pool"`
+	Address string `toml:"Address"`
+}
+
+// LendingPosition is the state of a lending protocol position, valued in USD
+type LendingPosition struct {
+	Collateral   float64
+	Debt         float64
+	HealthFactor float64
+}
+
+// CometAsset is a collateral asset of a Compound v3 Comet market
+type CometAsset struct {
+	Address   string
+	PriceFeed string
+	// Scale is 10 to the power of the asset's decimals
+	Scale *big.Int
+	// LiquidateFactor is the share of the asset's value that counts towards
+	// the borrow before liquidation, with 18 decimals
+	LiquidateFactor *big.Int
+}
+
+// cometAssets caches each Comet market's collateral assets, which only change
+// through governance
+var cometAssets = map[string][]CometAsset{}
+
+var lendingCollateralGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "lending_collateral_usd",
+	Help:      "Value of collateral supplied to a lending protocol in USD",
+}, []string{"position"})
+
+var lendingDebtGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "lending_debt_usd",
+	Help:      "Value of debt borrowed from a lending protocol in USD",
+}, []string{"position"})
+
+var lendingHealthFactorGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "lending_health_factor",
+	Help:      "Health factor of a lending position, liquidatable below 1",
+}, []string{"position"})
+
+// PrepareLendingGauges registers the lending metrics when positions are configured
+func PrepareLendingGauges(config *Config) {
+	if len(config.Lending) == 0 {
+		return
+	}
+	prometheus.Register(lendingCollateralGauge)
+	prometheus.Register(lendingDebtGauge)
+	prometheus.Register(lendingHealthFactorGauge)
+}
+
+// UpdateLending reads every configured lending position and updates its metrics
+func UpdateLending(ctx context.Context, config *Config) {
+	for _, lending := range config.Lending {
+		position, err := GetLendingPosition(ctx, config.EthereumRPC, lending)
+		if err != nil {
+			fmt.Println("Lending", lending.Name+":", err)
+			continue
+		}
+		name := strings.ToLower(lending.Name)
+		lendingCollateralGauge.WithLabelValues(name).Set(position.Collateral)
+		lendingDebtGauge.WithLabelValues(name).Set(position.Debt)
+		lendingHealthFactorGauge.WithLabelValues(name).Set(position.HealthFactor)
+	}
+}
+
+// GetLendingPosition reads a lending position on-chain
+func GetLendingPosition(ctx context.Context, endpoint string, lending LendingConfig) (*LendingPosition, error) {
+	switch strings.ToLower(lending.Protocol) {
+	case "", "aave":
+		return GetAavePosition(ctx, endpoint, lending.Pool, lending.Address)
+	case "compound":
+		return GetCometPosition(ctx, endpoint, lending.Pool, lending.Address)
+	}
+	return nil, errors.New("Unsupported lending protocol: " + lending.Protocol)
+}
+
+// GetAavePosition reads an address's account data from an Aave v3 Pool
+func GetAavePosition(ctx context.Context, endpoint string, pool string, address string) (*LendingPosition, error) {
+	data, err := EthCall(ctx, endpoint, pool, getUserAccountDataSelector+ABIAddress(address))
+	if err != nil {
+		return nil, err
+	}
+	collateral, err := ABIWord(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	debt, err := ABIWord(data, 1)
+	if err != nil {
+		return nil, err
+	}
+	healthFactor, err := ABIWord(data, 5)
+	if err != nil {
+		return nil, err
+	}
+
+	position := &LendingPosition{
+		Collateral:   ScaleBig(collateral, aaveBaseDecimals),
+		Debt:         ScaleBig(debt, aaveBaseDecimals),
+		HealthFactor: ScaleBig(healthFactor, 18),
+	}
+	if debt.Sign() == 0 {
+		position.HealthFactor = math.Inf(1)
+	}
+	return position, nil
+}
+
+// GetCometPosition reads an address's position in a Compound v3 Comet market,
+// valuing it at the market's own price feeds. The health factor is the
+// collateral value weighted by each asset's liquidation factor over the debt,
+// so it is liquidatable below 1 as on Aave.
+func GetCometPosition(ctx context.Context, endpoint string, comet string, address string) (*LendingPosition, error) {
+	assets, err := GetCometAssets(ctx, endpoint, comet)
+	if err != nil {
+		return nil, err
+	}
+	position := &LendingPosition{}
+	liquidationLimit := 0.0
+	for _, asset := range assets {
+		data, err := EthCall(ctx, endpoint, comet, collateralBalanceOfSelector+ABIAddress(address)+ABIAddress(asset.Address))
+		if err != nil {
+			return nil, err
+		}
+		balance, err := ABIWord(data, 0)
+		if err != nil {
+			return nil, err
+		}
+		if balance.Sign() == 0 {
+			continue
+		}
+		price, err := GetCometPrice(ctx, endpoint, comet, asset.PriceFeed)
+		if err != nil {
+			return nil, err
+		}
+		value := ScaleBy(balance, asset.Scale) * price
+		position.Collateral = position.Collateral + value
+		liquidationLimit = liquidationLimit + value*ScaleBig(asset.LiquidateFactor, 18)
+	}
+
+	data, err := EthCall(ctx, endpoint, comet, borrowBalanceOfSelector+ABIAddress(address))
+	if err != nil {
+		return nil, err
+	}
+	borrowed, err := ABIWord(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if borrowed.Sign() == 0 {
+		position.HealthFactor = math.Inf(1)
+		return position, nil
+	}
+	data, err = EthCall(ctx, endpoint, comet, baseScaleSelector)
+	if err != nil {
+		return nil, err
+	}
+	baseScale, err := ABIWord(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	data, err = EthCall(ctx, endpoint, comet, baseTokenPriceFeedSelector)
+	if err != nil {
+		return nil, err
+	}
+	baseFeed, err := ABIWordAddress(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	basePrice, err := GetCometPrice(ctx, endpoint, comet, baseFeed)
+	if err != nil {
+		return nil, err
+	}
+	position.Debt = ScaleBy(borrowed, baseScale) * basePrice
+	position.HealthFactor = liquidationLimit / position.Debt
+	return position, nil
+}
+
+// GetCometAssets returns the collateral assets of a Comet market, read once
+func GetCometAssets(ctx context.Context, endpoint string, comet string) ([]CometAsset, error) {
+	key := strings.ToLower(comet)
+	if assets, ok := cometAssets[key]; ok {
+		return assets, nil
+	}
+	data, err := EthCall(ctx, endpoint, comet, numAssetsSelector)
+	if err != nil {
+		return nil, err
+	}
+	count, err := ABIWord(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	assets := []CometAsset{}
+	for i := int64(0); i < count.Int64(); i++ {
+		info, err := EthCall(ctx, endpoint, comet, getAssetInfoSelector+fmt.Sprintf("%064x", i))
+		if err != nil {
+			return nil, err
+		}
+		// AssetInfo is offset, asset, priceFeed, scale, borrowCollateralFactor,
+		// liquidateCollateralFactor, liquidationFactor and supplyCap
+		asset := CometAsset{}
+		asset.Address, err = ABIWordAddress(info, 1)
+		if err != nil {
+			return nil, err
+		}
+		asset.PriceFeed, err = ABIWordAddress(info, 2)
+		if err != nil {
+			return nil, err
+		}
+		asset.Scale, err = ABIWord(info, 3)
+		if err != nil {
+			return nil, err
+		}
+		asset.LiquidateFactor, err = ABIWord(info, 5)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+	cometAssets[key] = assets
+	return assets, nil
+}
+
+// GetCometPrice reads a Comet price feed in USD
+func GetCometPrice(ctx context.Context, endpoint string, comet string, feed string) (float64, error) {
+	data, err := EthCall(ctx, endpoint, comet, getPriceSelector+ABIAddress(feed))
+	if err != nil {
+		return 0, err
+	}
+	price, err := ABIWord(data, 0)
+	if err != nil {
+		return 0, err
+	}
+	return ScaleBig(price, cometPriceDecimals), nil
+}
+
+// ScaleBy converts an integer amount to a float given its scale, such as 1e18
+func ScaleBy(n *big.Int, scale *big.Int) float64 {
+	if scale.Sign() == 0 {
+		return 0
+	}
+	v, _ := new(big.Float).Quo(new(big.Float).SetInt(n), new(big.Float).SetInt(scale)).Float64()
+	return v
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ListenerConfig configures an HTTP listener
+type ListenerConfig struct {
+	// BindAddress is the address to listen on
+	BindAddress string `toml:"BindAddress"`
+	// TLSCert and TLSKey are PEM files, serving HTTPS when both are set
+	TLSCert string `toml:"TLSCert"`
+	TLSKey  string `toml:"TLSKey"`
+	// Username and Password require HTTP basic auth when set
+	Username string `toml:"Username"`
+	Password string `toml:"Password"`
+}
+
+// ParseListener checks a listener's TLS settings
+func ParseListener(listener *ListenerConfig) error {
+	if (listener.TLSCert == "") != (listener.TLSKey == "") {
+		return errors.New("TLSCert and TLSKey must be set together")
+	}
+	return nil
+}
+
+// BasicAuth is middleware requiring the listener's credentials, passing
+// everything through when it has none
+func BasicAuth(listener ListenerConfig) func(next http.Handler) http.Handler {
+	if listener.Username == "" && listener.Password == "" {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			username, password, ok := r.BasicAuth()
+			userOK := subtle.ConstantTimeCompare([]byte(username), []byte(listener.Username)) == 1
+			passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(listener.Password)) == 1
+			if !ok || !userOK || !passwordOK {
+				w.Header().Set("WWW-Authenticate", `Basic realm="portfolio-metrics"`)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// Serve serves handler on the listener, over TLS when configured
+func Serve(listener ListenerConfig, handler http.Handler) error {
+	if listener.TLSCert != "" {
+		fmt.Println("Starting on", listener.BindAddress, "with TLS")
+		return http.ListenAndServeTLS(listener.BindAddress, listener.TLSCert, listener.TLSKey, handler)
+	}
+	fmt.Println("Starting on", listener.BindAddress)
+	return http.ListenAndServe(listener.BindAddress, handler)
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// Lot matching methods deciding which open lots a disposal consumes
+const (
+	MethodFIFO = "fifo"
+	MethodLIFO = "lifo"
+	MethodHIFO = "hifo"
+)
+
+// Transfers between accounts are matched by a send and a receive of the same
+// coin within TransferWindow, the receive being short of the send by at most
+// TransferTolerance for fees deducted on the way
+const (
+	TransferWindow    = 24 * time.Hour
+	TransferTolerance = 0.01
+)
+
+// Lot is an amount of a coin acquired together, with its remaining cost basis
+type Lot struct {
+	Time   time.Time
+	Amount float64
+	Cost   float64
+}
+
+// Position is the open lots of a coin held in an account
+type Position struct {
+	Account string
+	Coin    string
+	Lots    []Lot
+}
+
+// Amount is the total amount held across the open lots
+func (p *Position) Amount() float64 {
+	total := 0.0
+	for _, lot := range p.Lots {
+		total = total + lot.Amount
+	}
+	return total
+}
+
+// CostBasis is the total cost of the open lots
+func (p *Position) CostBasis() float64 {
+	total := 0.0
+	for _, lot := range p.Lots {
+		total = total + lot.Cost
+	}
+	return total
+}
+
+// Pool is a coin held across every account as one amount at its average
+// cost. Acquisitions add to it and anything leaving the tracked accounts
+// takes cost out in proportion to the amount, whichever lots it matched.
+type Pool struct {
+	Amount float64
+	Cost   float64
+}
+
+// add pools an acquisition
+func (p *Pool) add(amount float64, cost float64) {
+	p.Amount = p.Amount + amount
+	p.Cost = p.Cost + cost
+}
+
+// remove takes an amount out of the pool at its average cost
+func (p *Pool) remove(amount float64) {
+	if amount >= p.Amount {
+		p.Amount, p.Cost = 0, 0
+		return
+	}
+	p.Cost = p.Cost * (p.Amount - amount) / p.Amount
+	p.Amount = p.Amount - amount
+}
+
+// Disposal is the part of a disposal matched against a single lot
+type Disposal struct {
+	Account  string    `json:"account"`
+	Coin     string    `json:"coin"`
+	Acquired time.Time `json:"acquired"`
+	Disposed time.Time `json:"disposed"`
+	Amount   float64   `json:"amount"`
+	Proceeds float64   `json:"proceeds"`
+	Cost     float64   `json:"cost"`
+	Gain     float64   `json:"gain"`
+	// Unmatched is set on the part of a disposal no open lot covered, which
+	// is given no cost basis
+	Unmatched bool `json:"unmatched,omitempty"`
+}
+
+// Shortfall is an amount sent, sold or paid in fees beyond the open lots of
+// its account, usually because transactions are missing from the ledger
+type Shortfall struct {
+	Account string
+	Coin    string
+	Time    time.Time
+	Amount  float64
+}
+
+// Fee is a fee paid on a transaction. Cost is the cost basis expensed by a
+// fee paid on its own or on a send, since other fees are already part of
+// their lot or disposal.
+type Fee struct {
+	Account string
+	Coin    string
+	Time    time.Time
+	Amount  float64
+	Value   float64
+	Cost    float64
+}
+
+// Book tracks the open lots of every account and coin as transactions are
+// applied, matching disposals against lots with its method
+type Book struct {
+	Method string
+	// TaxableOuts treats sends not matched to a transfer as disposals at
+	// their value, rather than withdrawals out of the tracked accounts
+	TaxableOuts bool
+	Positions   map[string]*Position
+	Disposals   []Disposal
+	Fees        []Fee
+	Shortfalls  []Shortfall
+	// Pools holds each coin at its average cost, alongside the lots
+	Pools map[string]*Pool
+	// Income is the cumulative value of income received per kind and coin
+	Income map[IncomeKey]float64
+	// transfers maps the receive of each transfer to its send
+	transfers map[*Transaction]*Transaction
+	// transferred holds the lots of each send until they are received
+	transferred map[*Transaction][]Lot
+}
+
+// IncomeKey identifies a kind of income received in a coin
+type IncomeKey struct {
+	Kind string
+	Coin string
+}
+
+// NewBook returns an empty book using the given lot matching method
+func NewBook(method string) *Book {
+	return &Book{
+		Method:      method,
+		Positions:   map[string]*Position{},
+		Disposals:   []Disposal{},
+		Fees:        []Fee{},
+		Shortfalls:  []Shortfall{},
+		Pools:       map[string]*Pool{},
+		Income:      map[IncomeKey]float64{},
+		transfers:   map[*Transaction]*Transaction{},
+		transferred: map[*Transaction][]Lot{},
+	}
+}
+
+// BuildBook applies the transactions in time order to a new book, carrying
+// lots across transfers between accounts
+func BuildBook(txs []Transaction, method string, taxableOuts bool) *Book {
+	sorted := append([]Transaction{}, txs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+	book := NewBook(method)
+	book.TaxableOuts = taxableOuts
+	book.MatchTransfers(sorted)
+	for i := range sorted {
+		book.Apply(&sorted[i])
+	}
+	return book
+}
+
+// MatchTransfers pairs each send with the first unmatched receive of the same
+// coin into another account within TransferWindow of it. The transactions
+// must be sorted by time and applied in the same slice afterwards.
+func (b *Book) MatchTransfers(sorted []Transaction) {
+	received := map[*Transaction]bool{}
+	for i := range sorted {
+		out := &sorted[i]
+		if out.Type != TransactionOut {
+			continue
+		}
+		for j := i + 1; j < len(sorted); j++ {
+			in := &sorted[j]
+			if in.Time.Sub(out.Time) > TransferWindow {
+				break
+			}
+			if in.Type != TransactionIn || received[in] || in.Coin != out.Coin || in.Account == out.Account {
+				continue
+			}
+			if in.Amount > out.Amount || in.Amount < out.Amount*(1-TransferTolerance) {
+				continue
+			}
+			received[in] = true
+			b.transfers[in] = out
+			b.transferred[out] = nil
+			break
+		}
+	}
+}
+
+// Holding is a coin's open lots and realized P&L aggregated across accounts
+type Holding struct {
+	Amount    float64
+	CostBasis float64
+	// AverageCost is the cost per coin of the coin's pool
+	AverageCost float64
+	// Realized is the gain of the coin's disposals less the fees expensed
+	Realized float64
+}
+
+// Holdings aggregates the book per coin
+func (b *Book) Holdings() map[string]*Holding {
+	holdings := map[string]*Holding{}
+	holding := func(coin string) *Holding {
+		h, ok := holdings[coin]
+		if !ok {
+			h = &Holding{}
+			holdings[coin] = h
+		}
+		return h
+	}
+	for _, position := range b.Positions {
+		h := holding(position.Coin)
+		h.Amount = h.Amount + position.Amount()
+		h.CostBasis = h.CostBasis + position.CostBasis()
+	}
+	for coin, pool := range b.Pools {
+		if pool.Amount > 0 {
+			holding(coin).AverageCost = pool.Cost / pool.Amount
+		}
+	}
+	for _, disposal := range b.Disposals {
+		h := holding(disposal.Coin)
+		h.Realized = h.Realized + disposal.Gain
+	}
+	for _, fee := range b.Fees {
+		h := holding(fee.Coin)
+		h.Realized = h.Realized - fee.Cost
+	}
+	return holdings
+}
+
+// Pool returns the pool of a coin, creating it if needed
+func (b *Book) Pool(coin string) *Pool {
+	pool, ok := b.Pools[coin]
+	if !ok {
+		pool = &Pool{}
+		b.Pools[coin] = pool
+	}
+	return pool
+}
+
+// Position returns the position of a coin in an account, creating it if needed
+func (b *Book) Position(account string, coin string) *Position {
+	key := account + "/" + coin
+	position, ok := b.Positions[key]
+	if !ok {
+		position = &Position{Account: account, Coin: coin, Lots: []Lot{}}
+		b.Positions[key] = position
+	}
+	return position
+}
+
+// Apply records a transaction, opening a lot for acquisitions and matching
+// disposals and fees against the open lots. Fees are part of P&L: a fee on
+// an acquisition comes out of the new lot without reducing its cost, a fee
+// on a disposal is disposed of with it for no extra proceeds, and a fee on
+// its own or on a send is expensed at the cost of the coins spent.
+//
+// A send matched to a receive by MatchTransfers moves its lots to the
+// receiving account with their acquisition time and full cost, so a fee
+// deducted on the way is added to the cost of what arrives. Other sends are
+// withdrawals that realize nothing, unless the book has TaxableOuts.
+func (b *Book) Apply(tx *Transaction) {
+	position := b.Position(tx.Account, tx.Coin)
+	pool := b.Pool(tx.Coin)
+	fee := Fee{Account: tx.Account, Coin: tx.Coin, Time: tx.Time, Amount: tx.Fee, Value: tx.FeeValue}
+	_, sent := b.transferred[tx]
+	switch {
+	case b.transfers[tx] != nil:
+		out := b.transfers[tx]
+		lots := b.transferred[out]
+		delete(b.transferred, out)
+		carried := 0.0
+		for _, lot := range lots {
+			carried = carried + lot.Amount
+		}
+		for _, lot := range lots {
+			lot.Amount = lot.Amount * tx.Amount / out.Amount
+			position.add(lot)
+		}
+		// The pool keeps its cost, losing only what was deducted on the way
+		pool.Amount = pool.Amount + tx.Amount*carried/out.Amount - carried
+		if carried < out.Amount {
+			// The part the sending account didn't hold arrives at its value
+			missing := (out.Amount - carried) / out.Amount
+			position.add(Lot{Time: tx.Time, Amount: tx.Amount * missing, Cost: tx.Value * missing})
+			pool.add(tx.Amount*missing, tx.Value*missing)
+		}
+	case tx.Acquires():
+		position.add(Lot{Time: tx.Time, Amount: tx.Amount - tx.Fee, Cost: tx.Value})
+		pool.add(tx.Amount-tx.Fee, tx.Value)
+		if tx.Type == TransactionIncome {
+			key := IncomeKey{Kind: tx.Label, Coin: tx.Coin}
+			b.Income[key] = b.Income[key] + tx.Value
+		}
+	case sent || (tx.Type == TransactionOut && !b.TaxableOuts):
+		lots, _ := b.dispose(position, tx.Amount, tx.Time)
+		if sent {
+			b.transferred[tx] = lots
+		} else {
+			pool.remove(tx.Amount)
+		}
+		fee.Cost = b.expense(position, tx.Fee, tx.Time)
+		pool.remove(tx.Fee)
+	case tx.Disposes():
+		disposed := tx.Amount + tx.Fee
+		pool.remove(disposed)
+		matched, missing := b.dispose(position, disposed, tx.Time)
+		if missing > 0 {
+			matched = append(matched, Lot{Amount: missing})
+		}
+		for _, lot := range matched {
+			disposal := Disposal{
+				Account:   tx.Account,
+				Coin:      tx.Coin,
+				Acquired:  lot.Time,
+				Disposed:  tx.Time,
+				Amount:    lot.Amount,
+				Proceeds:  tx.Value * lot.Amount / disposed,
+				Cost:      lot.Cost,
+				Unmatched: lot.Time.IsZero(),
+			}
+			disposal.Gain = disposal.Proceeds - disposal.Cost
+			b.Disposals = append(b.Disposals, disposal)
+		}
+	default:
+		fee.Cost = b.expense(position, tx.Fee, tx.Time)
+		pool.remove(tx.Fee)
+	}
+	if tx.Fee > 0 || tx.FeeValue > 0 {
+		b.Fees = append(b.Fees, fee)
+	}
+}
+
+// add inserts a lot among the open lots in acquisition order
+func (p *Position) add(lot Lot) {
+	i := sort.Search(len(p.Lots), func(i int) bool {
+		return p.Lots[i].Time.After(lot.Time)
+	})
+	p.Lots = append(p.Lots, Lot{})
+	copy(p.Lots[i+1:], p.Lots[i:])
+	p.Lots[i] = lot
+}
+
+// expense disposes of a fee paid in the coin, returning the cost of the coins spent
+func (b *Book) expense(position *Position, amount float64, at time.Time) float64 {
+	matched, _ := b.dispose(position, amount, at)
+	cost := 0.0
+	for _, lot := range matched {
+		cost = cost + lot.Cost
+	}
+	return cost
+}
+
+// dispose removes an amount from the open lots chosen by the book's method,
+// returning the parts of each lot that were removed and the amount the lots
+// fell short by, which is recorded as a shortfall
+func (b *Book) dispose(position *Position, amount float64, at time.Time) ([]Lot, float64) {
+	matched := []Lot{}
+	for amount > 0 && len(position.Lots) > 0 {
+		i := b.nextLot(position.Lots)
+		lot := &position.Lots[i]
+		if lot.Amount > amount {
+			cost := lot.Cost * amount / lot.Amount
+			matched = append(matched, Lot{Time: lot.Time, Amount: amount, Cost: cost})
+			lot.Cost = lot.Cost - cost
+			lot.Amount = lot.Amount - amount
+			return matched, 0
+		}
+		matched = append(matched, *lot)
+		amount = amount - lot.Amount
+		position.Lots = append(position.Lots[:i], position.Lots[i+1:]...)
+	}
+	// Ignore float dust left over from splitting lots
+	if amount > 1e-9 {
+		b.Shortfalls = append(b.Shortfalls, Shortfall{Account: position.Account, Coin: position.Coin, Time: at, Amount: amount})
+		return matched, amount
+	}
+	return matched, 0
+}
+
+// nextLot picks the index of the lot the next disposal is matched against
+func (b *Book) nextLot(lots []Lot) int {
+	switch b.Method {
+	case MethodLIFO:
+		return len(lots) - 1
+	case MethodHIFO:
+		highest := 0
+		for i, lot := range lots {
+			if lot.Cost*lots[highest].Amount > lots[highest].Cost*lot.Amount {
+				highest = i
+			}
+		}
+		return highest
+	}
+	return 0
+}
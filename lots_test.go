@@ -0,0 +1,293 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func day(n int) time.Time {
+	return time.Date(2023, 1, n, 12, 0, 0, 0, time.UTC)
+}
+
+func near(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestBookMatching(t *testing.T) {
+	buys := []Transaction{
+		{Time: day(1), Type: TransactionBuy, Coin: "BTC", Amount: 1, Value: 100, Account: "a"},
+		{Time: day(2), Type: TransactionBuy, Coin: "BTC", Amount: 1, Value: 300, Account: "a"},
+		{Time: day(3), Type: TransactionBuy, Coin: "BTC", Amount: 1, Value: 200, Account: "a"},
+	}
+	tests := []struct {
+		name      string
+		method    string
+		sell      float64
+		proceeds  float64
+		costs     []float64
+		remaining float64
+	}{
+		{"fifo whole lot", MethodFIFO, 1, 250, []float64{100}, 500},
+		{"lifo whole lot", MethodLIFO, 1, 250, []float64{200}, 400},
+		{"hifo whole lot", MethodHIFO, 1, 250, []float64{300}, 300},
+		{"fifo partial lot", MethodFIFO, 0.5, 125, []float64{50}, 550},
+		{"fifo across lots", MethodFIFO, 1.5, 375, []float64{100, 150}, 350},
+		{"lifo across lots", MethodLIFO, 1.5, 375, []float64{200, 150}, 250},
+		{"hifo across lots", MethodHIFO, 1.5, 375, []float64{300, 100}, 200},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			txs := append([]Transaction{}, buys...)
+			txs = append(txs, Transaction{Time: day(4), Type: TransactionSell, Coin: "BTC", Amount: test.sell, Value: test.proceeds, Account: "a"})
+			book := BuildBook(txs, test.method, false)
+			if len(book.Disposals) != len(test.costs) {
+				t.Fatalf("got %d disposals, want %d", len(book.Disposals), len(test.costs))
+			}
+			proceeds := 0.0
+			for i, disposal := range book.Disposals {
+				if !near(disposal.Cost, test.costs[i]) {
+					t.Errorf("disposal %d cost %v, want %v", i, disposal.Cost, test.costs[i])
+				}
+				if !near(disposal.Gain, disposal.Proceeds-disposal.Cost) {
+					t.Errorf("disposal %d gain %v is not proceeds less cost", i, disposal.Gain)
+				}
+				proceeds = proceeds + disposal.Proceeds
+			}
+			if !near(proceeds, test.proceeds) {
+				t.Errorf("proceeds %v, want %v", proceeds, test.proceeds)
+			}
+			position := book.Position("a", "BTC")
+			if !near(position.Amount(), 3-test.sell) || !near(position.CostBasis(), test.remaining) {
+				t.Errorf("left %v BTC costing %v, want %v costing %v", position.Amount(), position.CostBasis(), 3-test.sell, test.remaining)
+			}
+		})
+	}
+}
+
+func TestBookFees(t *testing.T) {
+	buy := Transaction{Time: day(1), Type: TransactionBuy, Coin: "ETH", Amount: 10, Value: 1000, Account: "a"}
+	tests := []struct {
+		name      string
+		txs       []Transaction
+		amount    float64
+		basis     float64
+		gain      float64
+		feeCost   float64
+		realized  float64
+		feesTotal float64
+	}{
+		{
+			name:   "fee on a buy comes out of the lot",
+			txs:    []Transaction{{Time: day(1), Type: TransactionBuy, Coin: "ETH", Amount: 10, Value: 1000, Fee: 1, FeeValue: 100, Account: "a"}},
+			amount: 9, basis: 1000, feesTotal: 100,
+		},
+		{
+			name: "fee on a sale is disposed of for no proceeds",
+			txs: []Transaction{buy,
+				{Time: day(2), Type: TransactionSell, Coin: "ETH", Amount: 4, Value: 600, Fee: 1, FeeValue: 150, Account: "a"}},
+			amount: 5, basis: 500, gain: 100, realized: 100, feesTotal: 150,
+		},
+		{
+			name: "fee on its own is expensed at cost",
+			txs: []Transaction{buy,
+				{Time: day(2), Type: TransactionFee, Coin: "ETH", Fee: 2, FeeValue: 300, Account: "a"}},
+			amount: 8, basis: 800, feeCost: 200, realized: -200, feesTotal: 300,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			book := BuildBook(test.txs, MethodFIFO, false)
+			holding := book.Holdings()["ETH"]
+			if !near(holding.Amount, test.amount) || !near(holding.CostBasis, test.basis) {
+				t.Errorf("holding %v costing %v, want %v costing %v", holding.Amount, holding.CostBasis, test.amount, test.basis)
+			}
+			gain := 0.0
+			for _, disposal := range book.Disposals {
+				gain = gain + disposal.Gain
+			}
+			if !near(gain, test.gain) {
+				t.Errorf("gain %v, want %v", gain, test.gain)
+			}
+			feeCost, feesTotal := 0.0, 0.0
+			for _, fee := range book.Fees {
+				feeCost = feeCost + fee.Cost
+				feesTotal = feesTotal + fee.Value
+			}
+			if !near(feeCost, test.feeCost) || !near(feesTotal, test.feesTotal) {
+				t.Errorf("fees cost %v valued %v, want %v valued %v", feeCost, feesTotal, test.feeCost, test.feesTotal)
+			}
+			if !near(holding.Realized, test.realized) {
+				t.Errorf("realized %v, want %v", holding.Realized, test.realized)
+			}
+		})
+	}
+}
+
+func TestBookTransfers(t *testing.T) {
+	buys := []Transaction{
+		{Time: day(1), Type: TransactionBuy, Coin: "BTC", Amount: 1, Value: 100, Account: "exchange"},
+		{Time: day(5), Type: TransactionBuy, Coin: "BTC", Amount: 1, Value: 500, Account: "wallet"},
+	}
+	tests := []struct {
+		name        string
+		taxableOuts bool
+		txs         []Transaction
+		disposals   int
+		cost        float64
+		exchange    float64
+		wallet      float64
+		walletBasis float64
+		feeCost     float64
+	}{
+		{
+			name: "matched transfer carries the lot",
+			txs: []Transaction{
+				{Time: day(10), Type: TransactionOut, Coin: "BTC", Amount: 0.999, Value: 999, Fee: 0.001, FeeValue: 1, Account: "exchange"},
+				{Time: day(10).Add(time.Hour), Type: TransactionIn, Coin: "BTC", Amount: 0.995, Value: 995, Account: "wallet"},
+			},
+			wallet: 1.995, walletBasis: 599.9, feeCost: 0.1,
+		},
+		{
+			name: "received lot keeps its acquisition order",
+			txs: []Transaction{
+				{Time: day(10), Type: TransactionOut, Coin: "BTC", Amount: 1, Value: 1000, Account: "exchange"},
+				{Time: day(10), Type: TransactionIn, Coin: "BTC", Amount: 1, Value: 1000, Account: "wallet"},
+				{Time: day(11), Type: TransactionSell, Coin: "BTC", Amount: 1, Value: 1000, Account: "wallet"},
+			},
+			disposals: 1, cost: 100, wallet: 1, walletBasis: 500,
+		},
+		{
+			name: "unmatched send is a withdrawal",
+			txs: []Transaction{
+				{Time: day(10), Type: TransactionOut, Coin: "BTC", Amount: 0.5, Value: 500, Fee: 0.1, FeeValue: 100, Account: "exchange"},
+			},
+			exchange: 0.4, wallet: 1, walletBasis: 500, feeCost: 10,
+		},
+		{
+			name:        "unmatched send is a disposal with TaxableOuts",
+			taxableOuts: true,
+			txs: []Transaction{
+				{Time: day(10), Type: TransactionOut, Coin: "BTC", Amount: 0.5, Value: 500, Account: "exchange"},
+			},
+			disposals: 1, cost: 50, exchange: 0.5, wallet: 1, walletBasis: 500,
+		},
+		{
+			name: "receive too far from the send is not a transfer",
+			txs: []Transaction{
+				{Time: day(10), Type: TransactionOut, Coin: "BTC", Amount: 1, Value: 1000, Account: "exchange"},
+				{Time: day(12), Type: TransactionIn, Coin: "BTC", Amount: 1, Value: 1200, Account: "wallet"},
+			},
+			wallet: 2, walletBasis: 1700,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			book := BuildBook(append(append([]Transaction{}, buys...), test.txs...), MethodFIFO, test.taxableOuts)
+			if len(book.Disposals) != test.disposals {
+				t.Errorf("got %d disposals, want %d", len(book.Disposals), test.disposals)
+			}
+			if test.disposals == 1 && !near(book.Disposals[0].Cost, test.cost) {
+				t.Errorf("disposal cost %v, want %v", book.Disposals[0].Cost, test.cost)
+			}
+			exchange, wallet := book.Position("exchange", "BTC"), book.Position("wallet", "BTC")
+			if !near(exchange.Amount(), test.exchange) {
+				t.Errorf("exchange holds %v, want %v", exchange.Amount(), test.exchange)
+			}
+			if !near(wallet.Amount(), test.wallet) || !near(wallet.CostBasis(), test.walletBasis) {
+				t.Errorf("wallet holds %v costing %v, want %v costing %v", wallet.Amount(), wallet.CostBasis(), test.wallet, test.walletBasis)
+			}
+			feeCost := 0.0
+			for _, fee := range book.Fees {
+				feeCost = feeCost + fee.Cost
+			}
+			if !near(feeCost, test.feeCost) {
+				t.Errorf("fees cost %v, want %v", feeCost, test.feeCost)
+			}
+			if len(book.Shortfalls) != 0 {
+				t.Errorf("unexpected shortfalls %v", book.Shortfalls)
+			}
+		})
+	}
+}
+
+func TestBookAverageCost(t *testing.T) {
+	buys := []Transaction{
+		{Time: day(1), Type: TransactionBuy, Coin: "BTC", Amount: 1, Value: 100, Account: "exchange"},
+		{Time: day(2), Type: TransactionBuy, Coin: "BTC", Amount: 1, Value: 300, Account: "wallet"},
+	}
+	tests := []struct {
+		name    string
+		method  string
+		txs     []Transaction
+		amount  float64
+		average float64
+	}{
+		{"fifo sale leaves the average", MethodFIFO, []Transaction{
+			{Time: day(3), Type: TransactionSell, Coin: "BTC", Amount: 1, Value: 400, Account: "wallet"},
+		}, 1, 200},
+		{"hifo sale leaves the average", MethodHIFO, []Transaction{
+			{Time: day(3), Type: TransactionSell, Coin: "BTC", Amount: 0.5, Value: 200, Account: "wallet"},
+		}, 1.5, 200},
+		{"buy fee raises the average", MethodFIFO, []Transaction{
+			{Time: day(3), Type: TransactionBuy, Coin: "BTC", Amount: 1, Value: 200, Fee: 0.5, FeeValue: 100, Account: "wallet"},
+		}, 2.5, 240},
+		{"transfer carries the average", MethodFIFO, []Transaction{
+			{Time: day(3), Type: TransactionOut, Coin: "BTC", Amount: 1, Value: 200, Account: "exchange"},
+			{Time: day(3), Type: TransactionIn, Coin: "BTC", Amount: 1, Value: 200, Account: "wallet"},
+			{Time: day(4), Type: TransactionSell, Coin: "BTC", Amount: 1, Value: 300, Account: "wallet"},
+		}, 1, 200},
+		{"withdrawal takes coins out at the average", MethodLIFO, []Transaction{
+			{Time: day(3), Type: TransactionOut, Coin: "BTC", Amount: 1, Value: 200, Account: "wallet"},
+			{Time: day(4), Type: TransactionBuy, Coin: "BTC", Amount: 1, Value: 400, Account: "wallet"},
+		}, 2, 300},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			book := BuildBook(append(append([]Transaction{}, buys...), test.txs...), test.method, false)
+			holding := book.Holdings()["BTC"]
+			if !near(holding.Amount, test.amount) || !near(holding.AverageCost, test.average) {
+				t.Errorf("holds %v at an average of %v, want %v at %v", holding.Amount, holding.AverageCost, test.amount, test.average)
+			}
+		})
+	}
+}
+
+func TestBookShortfall(t *testing.T) {
+	txs := []Transaction{
+		{Time: day(1), Type: TransactionBuy, Coin: "BTC", Amount: 1, Value: 100, Account: "a"},
+		{Time: day(2), Type: TransactionSell, Coin: "BTC", Amount: 1.5, Value: 300, Account: "a"},
+	}
+	book := BuildBook(txs, MethodFIFO, false)
+	if len(book.Shortfalls) != 1 || !near(book.Shortfalls[0].Amount, 0.5) {
+		t.Fatalf("shortfalls %v, want 0.5 BTC", book.Shortfalls)
+	}
+	if len(book.Disposals) != 2 {
+		t.Fatalf("got %d disposals, want 2", len(book.Disposals))
+	}
+	unmatched := book.Disposals[1]
+	if !unmatched.Unmatched || !near(unmatched.Proceeds, 100) || unmatched.Cost != 0 {
+		t.Errorf("unmatched disposal %+v, want proceeds 100 at no cost", unmatched)
+	}
+	if gain := book.Holdings()["BTC"].Realized; !near(gain, 200) {
+		t.Errorf("realized %v, want every proceed counted for 200", gain)
+	}
+}
+
+func TestBuildTaxReport(t *testing.T) {
+	txs := []Transaction{
+		{Time: day(1), Type: TransactionBuy, Coin: "BTC", Amount: 2, Value: 200, Account: "a"},
+		{Time: time.Date(2023, 4, 5, 12, 0, 0, 0, time.UTC), Type: TransactionSell, Coin: "BTC", Amount: 1, Value: 150, Account: "a"},
+		{Time: time.Date(2023, 4, 6, 12, 0, 0, 0, time.UTC), Type: TransactionSell, Coin: "BTC", Amount: 1, Value: 80, Account: "a"},
+	}
+	report := BuildTaxReport(BuildBook(txs, MethodFIFO, false), "GBP", time.April, 6)
+	if len(report.Years) != 2 {
+		t.Fatalf("got %d tax years, want 2", len(report.Years))
+	}
+	if report.Years[0].Year != 2022 || !near(report.Years[0].Gain, 50) {
+		t.Errorf("first year %d gained %v, want 2022 gaining 50", report.Years[0].Year, report.Years[0].Gain)
+	}
+	if report.Years[1].Year != 2023 || !near(report.Years[1].Gain, -20) {
+		t.Errorf("second year %d gained %v, want 2023 gaining -20", report.Years[1].Year, report.Years[1].Gain)
+	}
+}
@@ -1,24 +1,38 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"regexp"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var portfolioTotal *atomic.Value
+var portfolio *atomic.Value
+
+var coinMissing = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "portfolio_coin_missing",
+	Help: "Set when the provider no longer returns a configured coin and its last known price is used",
+}, []string{"coin"})
+
+// UpdateInterval is how often the portfolio is updated
+const UpdateInterval = 1 * time.Minute
+
+// ConfigPath is the config file read at startup
+const ConfigPath = "config.toml"
 
 // PriceAPIURL is the API endpoint for pricing data
 const PriceAPIURL = "https://min-api.cryptocompare.com/data/pricemulti"
@@ -28,50 +42,369 @@ type Config struct {
 	BindAddress string       `toml:"BindAddress"`
 	Currency    string       `toml:"Currency"`
 	Coins       []CoinConfig `toml:"Coins"`
+	// Aliases maps a configured coin name to the symbol the provider knows it by
+	Aliases map[string]string `toml:"Aliases"`
+	// FearGreed enables fetching the crypto Fear & Greed index each update
+	FearGreed bool `toml:"FearGreed"`
+	// Dominance enables exporting BTC and ETH market dominance each update
+	Dominance bool `toml:"Dominance"`
+	// Supply enables exporting supply and fully diluted valuation of held coins
+	Supply bool `toml:"Supply"`
+	// GasTracker enables exporting Ethereum gas prices each update
+	GasTracker bool `toml:"GasTracker"`
+	// EthereumRPC is the JSON-RPC endpoint of an Ethereum node
+	EthereumRPC string `toml:"EthereumRPC"`
+	// Funding lists perpetual pairs whose funding rates are exported
+	Funding []FundingConfig `toml:"Funding"`
+	// Pools lists liquidity pool positions valued from on-chain reserves
+	Pools []PoolConfig `toml:"Pools"`
+	// Lending lists lending protocol positions to track
+	Lending []LendingConfig `toml:"Lending"`
+	// NFTs lists NFT collections held, valued at their floor price
+	NFTs []NFTConfig `toml:"NFTs"`
+	// OpenSeaAPIKey authenticates floor price requests to OpenSea
+	OpenSeaAPIKey string `toml:"OpenSeaAPIKey"`
+	// Staking lists addresses whose native staking balances are added to the holdings
+	Staking []StakingConfig `toml:"Staking"`
+	// Ledger is the path of the transaction ledger, defaulting to ledger.csv
+	Ledger string `toml:"Ledger"`
+	// LotMethod is how disposals are matched against lots, fifo (default), lifo or hifo
+	LotMethod string `toml:"LotMethod"`
+	// TaxableOuts treats sends that aren't matched to a receive in another
+	// account as disposals, rather than withdrawals that realize nothing
+	TaxableOuts bool `toml:"TaxableOuts"`
+	// Snapshots is the path of the snapshot history, defaulting to snapshots.jsonl
+	Snapshots string `toml:"Snapshots"`
+	// Events is the path of the event history, defaulting to events.jsonl
+	Events string `toml:"Events"`
+	// SnapshotInterval is the minimum time between snapshots, defaulting to an hour
+	SnapshotInterval Duration `toml:"SnapshotInterval"`
+	// SnapshotDSN stores snapshots in Postgres instead of the Snapshots file when set
+	SnapshotDSN string `toml:"SnapshotDSN"`
+	// RiskFreeRate is the annual risk-free rate used for Sharpe and Sortino ratios
+	RiskFreeRate float64 `toml:"RiskFreeRate"`
+	// DailySnapshotTime is the local HH:MM an end-of-day snapshot is taken at
+	DailySnapshotTime string `toml:"DailySnapshotTime"`
+	// Timezone is the IANA zone days are counted in, defaulting to UTC
+	Timezone string `toml:"Timezone"`
+	// Location is the loaded Timezone
+	Location *time.Location `toml:"-"`
+	// Alerts configures alerts on the total portfolio value
+	Alerts AlertsConfig `toml:"Alerts"`
+	// Grafana configures pushing events to Grafana as annotations
+	Grafana GrafanaConfig `toml:"Grafana"`
+	// RateLimits limits requests per client IP for the ui, api and metrics route groups
+	RateLimits map[string]RateLimitConfig `toml:"RateLimits"`
+	// TrustProxyHeaders takes the client IP from X-Forwarded-For or X-Real-IP
+	TrustProxyHeaders bool `toml:"TrustProxyHeaders"`
+	// Precision configures decimals and rounding of displayed numbers
+	Precision PrecisionConfig `toml:"Precision"`
+	// Audit is the path of the append-only audit log, defaulting to audit.jsonl
+	Audit string `toml:"Audit"`
+	// Server configures TLS and auth for the UI and API listener, which binds
+	// to BindAddress unless Server.BindAddress is set
+	Server ListenerConfig `toml:"Server"`
+	// Metrics serves /metrics on its own listener when Metrics.BindAddress is set
+	Metrics ListenerConfig `toml:"Metrics"`
+	// StartupRetries is how many times the first price fetch is tried, defaulting to 5
+	StartupRetries int `toml:"StartupRetries"`
+	// DelayMetrics answers /metrics with 503 until the first valuation completes
+	DelayMetrics bool `toml:"DelayMetrics"`
+	// Tracing exports OpenTelemetry traces of update cycles
+	Tracing TracingConfig `toml:"Tracing"`
+	// HA elects one of several replicas to fetch from providers
+	HA HAConfig `toml:"HA"`
+	// Redis shares prices and the latest valuation between instances
+	Redis RedisConfig `toml:"Redis"`
+	// Heartbeat pings a dead man's switch after each update cycle
+	Heartbeat HeartbeatConfig `toml:"Heartbeat"`
+	// DCA lists recurring purchases planned, tracked against the ledger
+	DCA []DCAConfig `toml:"DCA"`
+	// Report configures the monthly performance report
+	Report ReportConfig `toml:"Report"`
+	// Email configures the SMTP server reports are emailed through
+	Email EmailConfig `toml:"Email"`
+	// Sheets appends snapshots to a Google Sheet
+	Sheets SheetsConfig `toml:"Sheets"`
 }
 
 // CoinConfig is the sub-config from the TOML file
 type CoinConfig struct {
 	Name   string  `toml:"Name"`
 	Amount float64 `toml:"Amount"`
+	// ID is the provider's own identifier for the asset, used instead of Name
+	// when several assets share a ticker
+	ID string `toml:"ID"`
+	// CoinGeckoID is CoinGecko's identifier for the coin, e.g. bitcoin, which
+	// supply metrics look the coin up by instead of its ticker
+	CoinGeckoID string `toml:"CoinGeckoID"`
+	// Uniswap prices the coin from a Uniswap v3 pool instead of the provider
+	Uniswap *UniswapConfig `toml:"Uniswap"`
+	// Chainlink prices the coin from a Chainlink price feed instead of the provider
+	Chainlink *ChainlinkConfig `toml:"Chainlink"`
+}
+
+// Duration is a time.Duration parsed from a string such as "1h30m"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+// Portfolio is the latest valuation of the configured coins
+type Portfolio struct {
+	Currency  string      `json:"currency"`
+	Total     float64     `json:"total"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	Coins     []CoinValue `json:"coins"`
+	// Positions are liquidity pool positions, included in Total
+	Positions []PositionValue `json:"positions"`
+	// Illiquid holds NFTs valued at their floor price, which are excluded from
+	// Total and summed in IlliquidTotal instead
+	Illiquid      []NFTValue `json:"illiquid"`
+	IlliquidTotal float64    `json:"illiquid_total"`
+}
+
+// CoinValue is the valuation of a single coin
+type CoinValue struct {
+	Name   string  `json:"name"`
+	Amount float64 `json:"amount"`
+	Price  float64 `json:"price"`
+	Value  float64 `json:"value"`
+	// Stale is set when the provider did not return the coin and the last
+	// known price, priced at PricedAt, was used instead
+	Stale    bool      `json:"stale"`
+	PricedAt time.Time `json:"priced_at"`
 }
 
 func main() {
-	portfolioTotal = &atomic.Value{}
-	config, err := ParseConfig()
+	portfolio = &atomic.Value{}
+	source, err := OpenConfigSource()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	raw, version, err := source.Read()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	config, err := ParseConfig(raw)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(os.Args) > 1 {
+		err = RunCommand(config, os.Args[1], os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	summary := SummariseConfig(config, raw)
+	err = RecordAudit(config, "startup", AuditConfigLoad, nil, summary)
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	snapshotStore, err = OpenSnapshotStore(config)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	redisPool = OpenRedis(config)
+	elector, err = OpenElector(config)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	alertState, err = LoadAlertState(config)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
+	PreparePanicMetrics()
+	err = PrepareTracing(config)
+	if err != nil {
+		fmt.Println(err)
+	}
 	coins := GetCoins(config)
+	RecordCoinChanges(config, coins)
 	gauges := PrepareGauges(coins, config.Currency)
-	UpdatePortfolio(config, coins, config.Currency, gauges)
-	StartSubscription(config, coins, config.Currency, gauges)
+	PrepareMarketGauges(config)
+	PreparePoolGauges(config)
+	PrepareLendingGauges(config)
+	PrepareNFTGauges(config)
+	PrepareStakingGauges(config)
+	PrepareLedgerMetrics()
+	PrepareDCAGauges(config)
+	PrepareATHGauges()
+	PrepareWatermarkGauges()
+	PrepareRiskGauges()
+	PrepareDailyGauges()
+	PrepareAlertGauges()
+	go func() {
+		func() {
+			defer Recover("update")
+			WarmUp(config, coins, config.Currency, gauges)
+		}()
+		reloader := &ConfigReloader{config: config, coins: coins, gauges: gauges, summary: summary, actor: source.String()}
+		StartSubscription(config, reloader, WatchConfig(source, version))
+	}()
+	StartDailySnapshots(config)
+	StartReports(config)
+
+	metrics := promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	}))
+	if config.DelayMetrics {
+		metrics = WhenReady(metrics)
+	}
+	r := NewRouter(config)
+	if config.Metrics.BindAddress != "" {
+		m := NewRouter(config)
+		m.Get("/readyz", GetReady())
+		m.With(BasicAuth(config.Metrics), RateLimit(config, RouteGroupMetrics)).Handle("/metrics", metrics)
+		go func() {
+			log.Fatalln(Serve(config.Metrics, m))
+		}()
+	}
+	r.Get("/readyz", GetReady())
+	r.Group(func(r chi.Router) {
+		r.Use(BasicAuth(config.Server))
+		if config.Metrics.BindAddress == "" {
+			r.With(RateLimit(config, RouteGroupMetrics)).Handle("/metrics", metrics)
+		}
+		r.Group(func(r chi.Router) {
+			r.Use(RateLimit(config, RouteGroupUI))
+			r.Get("/", GetPortfolio(config, gauges))
+			r.Get("/feed.atom", GetFeed(config))
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(RateLimit(config, RouteGroupAPI))
+			r.Get("/api/portfolio", GetPortfolioJSON(config))
+			r.Get("/api/alerts", GetAlertsJSON(config))
+			r.Get("/api/audit", GetAuditJSON(config))
+			r.Post("/api/whatif", PostWhatIf(config))
+			r.Get("/api/openapi.json", GetOpenAPI())
+		})
+	})
+	log.Fatalln(Serve(config.Server, r))
+}
+
+// NewRouter returns a router with the middleware shared by every listener
+func NewRouter(config *Config) chi.Router {
 	r := chi.NewRouter()
-	r.Handle("/metrics", promhttp.Handler())
-	r.Get("/", GetPortfolio(gauges))
-	fmt.Println("Starting on", config.BindAddress)
-	log.Fatalln(http.ListenAndServe(config.BindAddress, r))
+	r.Use(Recoverer)
+	if config.TrustProxyHeaders {
+		r.Use(middleware.RealIP)
+	}
+	r.Use(middleware.DefaultCompress)
+	return r
+}
+
+// RunCommand runs a subcommand instead of starting the exporter
+func RunCommand(config *Config, command string, args []string) error {
+	switch command {
+	case "import":
+		return RunImport(config, args)
+	case "export":
+		return RunExport(config, args)
+	case "tax-report":
+		return RunTaxReport(config, args)
+	case "backfill":
+		return RunBackfill(config, args)
+	case "report":
+		return RunReport(config, args)
+	}
+	return errors.New("Unknown command: " + command)
 }
 
 // GetPortfolio returns the total value of the portfolio
-func GetPortfolio(gauges map[string]prometheus.Gauge) http.HandlerFunc {
+func GetPortfolio(config *Config, gauges map[string]prometheus.Gauge) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(fmt.Sprintf("%.2f", portfolioTotal.Load())))
+		p := LoadPortfolio()
+		w.Write([]byte(FormatValue(config, p.Total, p.Currency)))
+	}
+
+	return fn
+}
+
+// GetPortfolioJSON returns the latest valuation of every coin as JSON, converted
+// to the currency query parameter when one is given
+func GetPortfolioJSON(config *Config) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		p := LoadPortfolio()
+		currency := p.Currency
+		if r.URL.Query().Get("currency") != "" {
+			var err error
+			currency, err = ParseCurrency(r.URL.Query().Get("currency"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		variant := ""
+		if currency != p.Currency {
+			variant = currency
+		}
+		if NotModified(w, r, p.UpdatedAt, variant) {
+			return
+		}
+		if currency != p.Currency {
+			if p.UpdatedAt.IsZero() {
+				http.Error(w, "Portfolio not valued yet", http.StatusServiceUnavailable)
+				return
+			}
+			rate, err := GetConversionRate(r.Context(), p.Currency, currency, p.UpdatedAt)
+			if err != nil {
+				fmt.Println(err)
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			p = ConvertPortfolio(p, currency, rate)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(RoundPortfolio(config, p))
 	}
 
 	return fn
 }
 
-// StartSubscription will update the portfolio every minute
-func StartSubscription(config *Config, coins []string, currency string, gauges map[string]prometheus.Gauge) {
-	ticker := time.NewTicker(1 * time.Minute)
+// LoadPortfolio returns the latest valuation, which is empty until the first update
+func LoadPortfolio() *Portfolio {
+	p, ok := portfolio.Load().(*Portfolio)
+	if !ok {
+		return &Portfolio{Coins: []CoinValue{}, Positions: []PositionValue{}, Illiquid: []NFTValue{}}
+	}
+	return p
+}
+
+// StartSubscription will update the portfolio every minute, applying config
+// changes between updates
+func StartSubscription(config *Config, reloader *ConfigReloader, changes <-chan []byte) {
+	ticker := time.NewTicker(UpdateInterval)
 	go func() {
 		for {
 			select {
 			case <-ticker.C:
-				UpdatePortfolio(config, coins, currency, gauges)
+				func() {
+					defer Recover("update")
+					Update(config, reloader.coins, config.Currency, reloader.gauges)
+				}()
+			case raw := <-changes:
+				func() {
+					defer Recover("config-reload")
+					reloader.Apply(raw)
+				}()
 			}
 		}
 	}()
@@ -91,13 +424,95 @@ func PrepareGauges(coins []string, currency string) map[string]prometheus.Gauge
 		prometheus.Register(gauge)
 		gauges[symbol] = gauge
 	}
+	prometheus.Register(coinMissing)
 	return gauges
 }
 
-// ParseConfig will parse config.toml into a struct
-func ParseConfig() (*Config, error) {
+// ParseConfig will parse the raw config, from config.toml or a remote source,
+// into a struct
+func ParseConfig(raw []byte) (*Config, error) {
 	conf := &Config{}
-	_, err := toml.DecodeFile("config.toml", conf)
+	b, err := DecryptConfig(raw)
+	if err != nil {
+		return nil, err
+	}
+	expanded, err := ExpandEnv(string(b))
+	if err != nil {
+		return nil, err
+	}
+	meta, err := toml.Decode(expanded, conf)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Ledger == "" {
+		conf.Ledger = "ledger.csv"
+	}
+	if conf.LotMethod == "" {
+		conf.LotMethod = MethodFIFO
+	}
+	if conf.Snapshots == "" {
+		conf.Snapshots = "snapshots.jsonl"
+	}
+	if conf.Events == "" {
+		conf.Events = "events.jsonl"
+	}
+	if conf.SnapshotInterval.Duration == 0 {
+		conf.SnapshotInterval.Duration = time.Hour
+	}
+	conf.Location, err = time.LoadLocation(conf.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	err = ParseUniswap(conf)
+	if err != nil {
+		return nil, err
+	}
+	err = ParseChainlink(conf)
+	if err != nil {
+		return nil, err
+	}
+	err = ParsePools(conf)
+	if err != nil {
+		return nil, err
+	}
+	err = ParseDCA(conf)
+	if err != nil {
+		return nil, err
+	}
+	err = ParseReport(conf)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Alerts.State == "" {
+		conf.Alerts.State = "alerts.json"
+	}
+	if conf.Alerts.ATHRearmPercent == 0 {
+		conf.Alerts.ATHRearmPercent = 5
+	}
+	if conf.Audit == "" {
+		conf.Audit = "audit.jsonl"
+	}
+	err = ParsePrecision(&conf.Precision, meta)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Redis.Prefix == "" {
+		conf.Redis.Prefix = "portfolio-metrics:"
+	}
+	if conf.HA.LockKey == 0 {
+		conf.HA.LockKey = DefaultHALockKey
+	}
+	if conf.StartupRetries == 0 {
+		conf.StartupRetries = 5
+	}
+	if conf.Server.BindAddress == "" {
+		conf.Server.BindAddress = conf.BindAddress
+	}
+	err = ParseListener(&conf.Server)
+	if err != nil {
+		return nil, err
+	}
+	err = ParseListener(&conf.Metrics)
 	if err != nil {
 		return nil, err
 	}
@@ -105,41 +520,220 @@ func ParseConfig() (*Config, error) {
 	return conf, nil
 }
 
-// GetCoins iterates over the config to get the list of coins
+var envPattern = regexp.MustCompile(`\$\{(keyring:[^}]+|[A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ExpandEnv replaces ${VAR} in the config with the environment variable VAR,
+// and ${keyring:NAME} with the secret NAME from the OS keyring, escaped for use
+// inside a TOML string. Missing values are an error rather than silently
+// empty. Comment lines are left alone.
+func ExpandEnv(text string) (string, error) {
+	var err error
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		lines[i] = envPattern.ReplaceAllStringFunc(line, func(match string) string {
+			name := envPattern.FindStringSubmatch(match)[1]
+			if strings.HasPrefix(name, "keyring:") {
+				value, keyringErr := GetKeyringSecret(strings.TrimPrefix(name, "keyring:"))
+				if keyringErr != nil {
+					err = keyringErr
+					return match
+				}
+				return escaper.Replace(value)
+			}
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				err = errors.New("Environment variable not set: " + name)
+				return match
+			}
+			return escaper.Replace(value)
+		})
+	}
+	return strings.Join(lines, "\n"), err
+}
+
+// GetCoins iterates over the config to get the list of coins, including coins
+// only held through staking
 func GetCoins(conf *Config) []string {
 	coins := []string{}
-	for _, coin := range conf.Coins {
+	seen := map[string]bool{}
+	for _, coin := range LiveCoins(conf) {
 		coins = append(coins, coin.Name)
+		seen[coin.Name] = true
+	}
+	for _, staking := range conf.Staking {
+		if !seen[staking.Coin] {
+			coins = append(coins, staking.Coin)
+			seen[staking.Coin] = true
+		}
 	}
 	return coins
 }
 
-// UpdatePortfolio will iterate over the coins and call the API getter func
-func UpdatePortfolio(config *Config, coins []string, currency string, gauges map[string]prometheus.Gauge) {
-	fmt.Println("Updating portfolio...")
-	prices, err := GetPrices(coins, currency)
+// Update runs a full update of the portfolio and every optional metric, traced
+// as one update cycle
+func Update(config *Config, coins []string, currency string, gauges map[string]prometheus.Gauge) {
+	TraceStage(context.Background(), "update", func(ctx context.Context) error {
+		if IsFollower(ctx) {
+			Follow(ctx, config, gauges)
+			return nil
+		}
+		err := TraceStage(ctx, "portfolio", func(ctx context.Context) error {
+			return UpdatePortfolio(ctx, config, coins, currency, gauges)
+		})
+		if err != nil {
+			fmt.Println(err)
+		}
+		UpdateDerived(ctx, config, currency)
+		Heartbeat(config, err)
+		return err
+	})
+}
+
+// UpdateDerived updates everything derived from the latest valuation, and the
+// optional metrics fetched alongside it
+func UpdateDerived(ctx context.Context, config *Config, currency string) {
+	err := TraceStage(ctx, "snapshot", func(ctx context.Context) error {
+		return RecordSnapshot(config)
+	})
 	if err != nil {
 		fmt.Println(err)
-		return
 	}
-	total := 0.0
-	for tsym, psyms := range prices {
-		symbol := strings.ToLower(tsym)
-		for pName, psym := range psyms {
+	stages := []struct {
+		name   string
+		update func(ctx context.Context)
+	}{
+		{"alerts", func(ctx context.Context) { CheckAlerts(config) }},
+		{"ath", func(ctx context.Context) { UpdateATH(ctx, config, currency) }},
+		{"watermarks", func(ctx context.Context) { UpdateWatermarks(config) }},
+		{"risk", func(ctx context.Context) { UpdateRisk(config) }},
+		{"daily", func(ctx context.Context) { UpdateDailyChange() }},
+		{"market", func(ctx context.Context) { UpdateMarket(ctx, config) }},
+		{"lending", func(ctx context.Context) { UpdateLending(ctx, config) }},
+		{"ledger", func(ctx context.Context) { UpdateLedger(config) }},
+	}
+	for _, stage := range stages {
+		update := stage.update
+		TraceStage(ctx, stage.name, func(ctx context.Context) error {
+			update(ctx)
+			return nil
+		})
+	}
+	err = TraceStage(ctx, "publish", func(ctx context.Context) error {
+		return PublishState()
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// UpdatePortfolio will iterate over the coins and call the API getter func,
+// returning an error when prices could not be fetched
+func UpdatePortfolio(ctx context.Context, config *Config, coins []string, currency string, gauges map[string]prometheus.Gauge) error {
+	fmt.Println("Updating portfolio...")
+	priced := append(append([]string{}, coins...), GetPoolTokens(config)...)
+	priced = append(priced, GetNFTTokens(config)...)
+	prices, err := FetchPrices(ctx, config, ProviderSymbols(config, ProviderCoins(config, priced)), currency)
+	if err != nil {
+		return err
+	}
+	AddOnChainPrices(ctx, config, prices, currency)
+	previous := map[string]CoinValue{}
+	for _, value := range LoadPortfolio().Coins {
+		previous[value.Name] = value
+	}
+	staked, rewards := GetStakedAmounts(ctx, config)
+	now := time.Now()
+	result := &Portfolio{Currency: currency, UpdatedAt: now, Coins: []CoinValue{}}
+	for _, coin := range coins {
+		value := CoinValue{Name: coin, Amount: GetAmount(config, coin) + staked[coin], Stale: true}
+		for pName, psym := range prices[ProviderSymbol(config, coin)] {
 			if strings.ToLower(pName) == strings.ToLower(currency) {
-				subtotal := psym * GetAmount(config, tsym)
-				gauges[symbol].Set(total)
-				total = total + subtotal
+				value.Price = psym
+				value.PricedAt = now
+				value.Stale = false
 			}
 		}
+		if value.Stale {
+			fmt.Println("No price returned for", coin+", using last known price")
+			value.Price = previous[coin].Price
+			value.PricedAt = previous[coin].PricedAt
+		}
+		value.Value = value.Price * value.Amount
+		result.Total = result.Total + value.Value
+		result.Coins = append(result.Coins, value)
+	}
+	result.Positions = ValuePools(ctx, config, prices, currency)
+	for _, position := range result.Positions {
+		result.Total = result.Total + position.Value
+	}
+	result.Illiquid = ValueNFTs(ctx, config, prices, currency)
+	for _, nft := range result.Illiquid {
+		result.IlliquidTotal = result.IlliquidTotal + nft.Value
+	}
+	DetectBalanceChanges(config, previous, result, rewards)
+	portfolio.Store(result)
+	SetCoinGauges(gauges, result)
+	SetReady()
+	err = PublishPortfolio(config, result)
+	if err != nil {
+		fmt.Println("Redis:", err)
+	}
+	return nil
+}
+
+// SetCoinGauges sets the price and missing gauges of every coin in a valuation
+func SetCoinGauges(gauges map[string]prometheus.Gauge, p *Portfolio) {
+	for _, value := range p.Coins {
+		symbol := strings.ToLower(value.Name)
+		if gauge, ok := gauges[symbol]; ok {
+			gauge.Set(value.Price)
+		}
+		coinMissing.WithLabelValues(symbol).Set(BoolFloat(value.Stale))
 	}
-	portfolioTotal.Store(total)
+}
 
+// ProviderSymbol resolves the symbol a coin is queried by, preferring an explicit
+// provider ID and otherwise following any configured aliases
+func ProviderSymbol(config *Config, name string) string {
+	for _, coin := range LiveCoins(config) {
+		if coin.Name == name && coin.ID != "" {
+			return coin.ID
+		}
+	}
+	aliases := LiveAliases(config)
+	symbol := name
+	for i := 0; i < len(aliases); i++ {
+		alias, ok := aliases[symbol]
+		if !ok || alias == symbol {
+			break
+		}
+		symbol = alias
+	}
+	return symbol
+}
+
+// ProviderSymbols resolves the unique provider symbols for a list of coins
+func ProviderSymbols(config *Config, coins []string) []string {
+	seen := map[string]bool{}
+	symbols := []string{}
+	for _, coin := range coins {
+		symbol := ProviderSymbol(config, coin)
+		if seen[symbol] {
+			continue
+		}
+		seen[symbol] = true
+		symbols = append(symbols, symbol)
+	}
+	return symbols
 }
 
 // GetAmount pulls the amount for a specific coin
 func GetAmount(config *Config, tsym string) float64 {
-	for _, coin := range config.Coins {
+	for _, coin := range LiveCoins(config) {
 		if coin.Name == tsym {
 			return coin.Amount
 		}
@@ -147,8 +741,12 @@ func GetAmount(config *Config, tsym string) float64 {
 	return 0
 }
 
-// GetPrices does the actual request to the API
-func GetPrices(coins []string, currency string) (PriceAPIResponse, error) {
+// GetPrices does the actual request to the API, skipping it when there are no
+// coins to price, such as when every coin is priced on-chain
+func GetPrices(ctx context.Context, coins []string, currency string) (PriceAPIResponse, error) {
+	if len(coins) == 0 {
+		return PriceAPIResponse{}, nil
+	}
 	u, err := url.Parse(PriceAPIURL)
 	if err != nil {
 		return nil, err
@@ -158,22 +756,8 @@ func GetPrices(coins []string, currency string) (PriceAPIResponse, error) {
 	q.Set("tsyms", currency)
 	u.RawQuery = q.Encode()
 
-	resp, err := http.Get(u.String())
-	if err != nil {
-		return nil, err
-	}
-	if resp.StatusCode > 299 {
-		return nil, errors.New("Bad status: " + resp.Status)
-	}
-
 	result := PriceAPIResponse{}
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	err = json.Unmarshal(b, &result)
+	err = GetJSON(ctx, u.String(), &result)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// FearGreedAPIURL is the API endpoint for the crypto Fear & Greed index
+const FearGreedAPIURL = "https://api.alternative.me/fng/?limit=1"
+
+// GlobalMarketAPIURL is the API endpoint for global market data
+const GlobalMarketAPIURL = "https://api.coingecko.com/api/v3/global"
+
+// MarketsAPIURL is the API endpoint for per coin market data
+const MarketsAPIURL = "https://api.coingecko.com/api/v3/coins/markets"
+
+// DominanceCoins are the coins whose market dominance is exported
+var DominanceCoins = []string{"btc", "eth"}
+
+var fearGreedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "fear_greed_index",
+	Help:      "Crypto Fear & Greed index, from 0 (extreme fear) to 100 (extreme greed)",
+})
+
+var dominanceGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "market_dominance_percent",
+	Help:      "Share of the total crypto market cap held by a coin",
+}, []string{"coin"})
+
+var circulatingSupplyGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "circulating_supply",
+	Help:      "Circulating supply of a coin",
+}, []string{"coin"})
+
+var maxSupplyGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "max_supply",
+	Help:      "Maximum supply of a coin, absent when uncapped",
+}, []string{"coin"})
+
+var fdvGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "fully_diluted_valuation",
+	Help:      "Fully diluted valuation of a coin in the portfolio currency",
+}, []string{"coin"})
+
+var gasBaseFeeGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "gas_base_fee_gwei",
+	Help:      "Ethereum base fee of the latest block in gwei",
+})
+
+var gasPriceGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "gas_price_gwei",
+	Help:      "Gas price suggested by the Ethereum node for prompt inclusion in gwei",
+})
+
+// PrepareMarketGauges registers the market context metrics enabled in the config
+func PrepareMarketGauges(config *Config) {
+	if config.FearGreed {
+		prometheus.Register(fearGreedGauge)
+	}
+	if config.Dominance {
+		prometheus.Register(dominanceGauge)
+	}
+	if config.Supply {
+		prometheus.Register(circulatingSupplyGauge)
+		prometheus.Register(maxSupplyGauge)
+		prometheus.Register(fdvGauge)
+	}
+	if config.GasTracker {
+		prometheus.Register(gasBaseFeeGauge)
+		prometheus.Register(gasPriceGauge)
+	}
+	if len(config.Funding) > 0 {
+		prometheus.Register(fundingGauge)
+	}
+}
+
+// UpdateMarket fetches the enabled market context metrics
+func UpdateMarket(ctx context.Context, config *Config) {
+	if config.FearGreed {
+		index, err := GetFearGreed(ctx)
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			fearGreedGauge.Set(index)
+		}
+	}
+	if config.Dominance {
+		dominance, err := GetDominance(ctx)
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			for _, coin := range DominanceCoins {
+				dominanceGauge.WithLabelValues(coin).Set(dominance[coin])
+			}
+		}
+	}
+	if config.Supply {
+		UpdateSupply(ctx, config)
+	}
+	if config.GasTracker {
+		baseFee, gasPrice, err := GetGasPrices(ctx, config.EthereumRPC)
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			gasBaseFeeGauge.Set(baseFee)
+			gasPriceGauge.Set(gasPrice)
+		}
+	}
+	for _, pair := range config.Funding {
+		rate, err := GetFundingRate(ctx, pair)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fundingGauge.WithLabelValues(strings.ToLower(pair.Exchange), strings.ToUpper(pair.Symbol)).Set(rate)
+	}
+}
+
+// GetFearGreed fetches the current Fear & Greed index
+func GetFearGreed(ctx context.Context) (float64, error) {
+	result := FearGreedAPIResponse{}
+	err := GetJSON(ctx, FearGreedAPIURL, &result)
+	if err != nil {
+		return 0, err
+	}
+	if len(result.Data) == 0 {
+		return 0, errors.New("No Fear & Greed data returned")
+	}
+	return strconv.ParseFloat(result.Data[0].Value, 64)
+}
+
+// GetDominance fetches the market cap percentage of each coin
+func GetDominance(ctx context.Context) (map[string]float64, error) {
+	result := GlobalMarketAPIResponse{}
+	err := GetJSON(ctx, GlobalMarketAPIURL, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Data.MarketCapPercentage, nil
+}
+
+// UpdateSupply exports supply and fully diluted valuation for every configured
+// coin. Coins with a CoinGeckoID are looked up by it, the others by ticker,
+// taking the coin with the largest market cap when several share the ticker.
+func UpdateSupply(ctx context.Context, config *Config) {
+	coins := GetCoins(config)
+	ids := []string{}
+	symbols := []string{}
+	for _, coin := range coins {
+		if id := CoinGeckoID(config, coin); id != "" {
+			ids = append(ids, id)
+		} else {
+			symbols = append(symbols, ProviderSymbol(config, coin))
+		}
+	}
+	byID := map[string]Market{}
+	if len(ids) > 0 {
+		markets, err := GetMarkets(ctx, "ids", ids, config.Currency)
+		if err != nil {
+			fmt.Println(err)
+		}
+		for _, market := range markets {
+			byID[market.ID] = market
+		}
+	}
+	bySymbol := map[string]Market{}
+	if len(symbols) > 0 {
+		markets, err := GetMarkets(ctx, "symbols", symbols, config.Currency)
+		if err != nil {
+			fmt.Println(err)
+		}
+		for _, market := range markets {
+			symbol := strings.ToLower(market.Symbol)
+			if largest, ok := bySymbol[symbol]; !ok || market.MarketCap > largest.MarketCap {
+				bySymbol[symbol] = market
+			}
+		}
+	}
+
+	for _, coin := range coins {
+		market, ok := Market{}, false
+		if id := CoinGeckoID(config, coin); id != "" {
+			market, ok = byID[id]
+		} else {
+			market, ok = bySymbol[strings.ToLower(ProviderSymbol(config, coin))]
+		}
+		if !ok {
+			continue
+		}
+		symbol := strings.ToLower(coin)
+		circulatingSupplyGauge.WithLabelValues(symbol).Set(market.CirculatingSupply)
+		if market.MaxSupply != nil {
+			maxSupplyGauge.WithLabelValues(symbol).Set(*market.MaxSupply)
+		}
+		if market.FullyDilutedValuation != nil {
+			fdvGauge.WithLabelValues(symbol).Set(*market.FullyDilutedValuation)
+		}
+	}
+}
+
+// CoinGeckoID returns the CoinGecko identifier configured for a coin, if any
+func CoinGeckoID(config *Config, name string) string {
+	for _, coin := range LiveCoins(config) {
+		if coin.Name == name {
+			return coin.CoinGeckoID
+		}
+	}
+	return ""
+}
+
+// GetMarkets fetches market data for coins by CoinGecko ids or by symbols,
+// depending on by. Looking up a symbol can return several coins.
+func GetMarkets(ctx context.Context, by string, coins []string, currency string) ([]Market, error) {
+	u, err := url.Parse(MarketsAPIURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("vs_currency", strings.ToLower(currency))
+	q.Set(by, strings.ToLower(strings.Join(coins, ",")))
+	u.RawQuery = q.Encode()
+
+	result := []Market{}
+	err = GetJSON(ctx, u.String(), &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetJSON requests a URL and decodes the JSON response into result
+func GetJSON(ctx context.Context, u string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	return DoJSON(req, result)
+}
+
+// DoJSON sends a request in its own span and decodes the JSON response into result
+func DoJSON(req *http.Request, result interface{}) error {
+	return TraceCall(req.Context(), req.Method+" "+req.URL.Host, req.URL.Host, func(ctx context.Context) error {
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode > 299 {
+			return errors.New("Bad status: " + resp.Status)
+		}
+
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+
+		return json.Unmarshal(b, result)
+	})
+}
+
+// FearGreedAPIResponse is the JSON response from the Fear & Greed API
+type FearGreedAPIResponse struct {
+	Data []struct {
+		Value          string `json:"value"`
+		Classification string `json:"value_classification"`
+	} `json:"data"`
+}
+
+// GlobalMarketAPIResponse is the JSON response from the global market API
+type GlobalMarketAPIResponse struct {
+	Data struct {
+		MarketCapPercentage map[string]float64 `json:"market_cap_percentage"`
+	} `json:"data"`
+}
+
+// Market is a coin's entry in the JSON response from the markets API
+type Market struct {
+	ID                    string   `json:"id"`
+	Symbol                string   `json:"symbol"`
+	MarketCap             float64  `json:"market_cap"`
+	CirculatingSupply     float64  `json:"circulating_supply"`
+	MaxSupply             *float64 `json:"max_supply"`
+	FullyDilutedValuation *float64 `json:"fully_diluted_valuation"`
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OpenSeaAPIURL is the API endpoint for OpenSea collection data
+const OpenSeaAPIURL = "https://api.opensea.io/api/v2"
+
+// NFTConfig is an NFT collection held, valued at its floor price
+type NFTConfig struct {
+	Name     string  `toml:"Name"`
+	Contract string  `toml:"Contract"`
+	Quantity float64 `toml:"Quantity"`
+	// Chain is the OpenSea chain the contract lives on, defaulting to ethereum
+	Chain string `toml:"Chain"`
+	// FloorSymbol is the coin floor prices are quoted in, defaulting to ETH
+	FloorSymbol string `toml:"FloorSymbol"`
+}
+
+// NFTValue is the floor valuation of an NFT collection
+type NFTValue struct {
+	Name       string  `json:"name"`
+	Quantity   float64 `json:"quantity"`
+	FloorPrice float64 `json:"floor_price"`
+	Value      float64 `json:"value"`
+}
+
+var nftFloorGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "nft_floor_price",
+	Help:      "Floor price of an NFT collection in the portfolio currency",
+}, []string{"collection"})
+
+var nftValueGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "nft_value",
+	Help:      "Value of the NFTs held in a collection at its floor price",
+}, []string{"collection"})
+
+var illiquidValueGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "illiquid_value",
+	Help:      "Value of the illiquid segment of the portfolio, excluded from the total",
+})
+
+var nftSlugs = map[string]string{}
+
+// PrepareNFTGauges registers the NFT metrics when collections are configured
+func PrepareNFTGauges(config *Config) {
+	if len(config.NFTs) == 0 {
+		return
+	}
+	prometheus.Register(nftFloorGauge)
+	prometheus.Register(nftValueGauge)
+	prometheus.Register(illiquidValueGauge)
+}
+
+// GetNFTTokens lists the coins needed to price the configured collections' floors
+func GetNFTTokens(conf *Config) []string {
+	tokens := []string{}
+	for _, nft := range conf.NFTs {
+		tokens = append(tokens, NFTFloorSymbol(nft))
+	}
+	return tokens
+}
+
+// NFTFloorSymbol returns the coin a collection's floor price is quoted in
+func NFTFloorSymbol(nft NFTConfig) string {
+	if nft.FloorSymbol == "" {
+		return "ETH"
+	}
+	return nft.FloorSymbol
+}
+
+// ValueNFTs fetches each collection's floor price and values the holdings with the given prices
+func ValueNFTs(ctx context.Context, config *Config, prices PriceAPIResponse, currency string) []NFTValue {
+	values := []NFTValue{}
+	total := 0.0
+	for _, nft := range config.NFTs {
+		floor, err := GetNFTFloor(ctx, config.OpenSeaAPIKey, nft)
+		if err != nil {
+			fmt.Println("NFT", nft.Name+":", err)
+			continue
+		}
+		value := NFTValue{
+			Name:       nft.Name,
+			Quantity:   nft.Quantity,
+			FloorPrice: floor * GetPrice(config, prices, NFTFloorSymbol(nft), currency),
+		}
+		value.Value = value.FloorPrice * nft.Quantity
+		total = total + value.Value
+
+		name := strings.ToLower(nft.Name)
+		nftFloorGauge.WithLabelValues(name).Set(value.FloorPrice)
+		nftValueGauge.WithLabelValues(name).Set(value.Value)
+		values = append(values, value)
+	}
+	if len(config.NFTs) > 0 {
+		illiquidValueGauge.Set(total)
+	}
+	return values
+}
+
+// GetNFTFloor fetches a collection's floor price, in its floor symbol
+func GetNFTFloor(ctx context.Context, apiKey string, nft NFTConfig) (float64, error) {
+	slug, err := GetNFTSlug(ctx, apiKey, nft)
+	if err != nil {
+		return 0, err
+	}
+	result := OpenSeaStatsAPIResponse{}
+	err = GetOpenSea(ctx, apiKey, "/collections/"+url.PathEscape(slug)+"/stats", &result)
+	if err != nil {
+		return 0, err
+	}
+	return result.Total.FloorPrice, nil
+}
+
+// GetNFTSlug resolves a collection contract to its OpenSea slug, caching the result
+func GetNFTSlug(ctx context.Context, apiKey string, nft NFTConfig) (string, error) {
+	chain := nft.Chain
+	if chain == "" {
+		chain = "ethereum"
+	}
+	key := chain + "/" + strings.ToLower(nft.Contract)
+	if slug, ok := nftSlugs[key]; ok {
+		return slug, nil
+	}
+
+	result := OpenSeaContractAPIResponse{}
+	err := GetOpenSea(ctx, apiKey, "/chain/"+url.PathEscape(chain)+"/contract/"+url.PathEscape(nft.Contract), &result)
+	if err != nil {
+		return "", err
+	}
+	if result.Collection == "" {
+		return "", errors.New("No collection found for contract " + nft.Contract)
+	}
+	nftSlugs[key] = result.Collection
+	return result.Collection, nil
+}
+
+// GetOpenSea requests an OpenSea API path and decodes the JSON response into result
+func GetOpenSea(ctx context.Context, apiKey string, path string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, OpenSeaAPIURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-API-KEY", apiKey)
+	return DoJSON(req, result)
+}
+
+// OpenSeaContractAPIResponse is the JSON response from the OpenSea contract API
+type OpenSeaContractAPIResponse struct {
+	Collection string `json:"collection"`
+}
+
+// OpenSeaStatsAPIResponse is the JSON response from the OpenSea collection stats API
+type OpenSeaStatsAPIResponse struct {
+	Total struct {
+		FloorPrice       float64 `json:"floor_price"`
+		FloorPriceSymbol string  `json:"floor_price_symbol"`
+	} `json:"total"`
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// webhookClient bounds webhook posts, which run on the update path
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// Notify logs a notification and posts it to the configured webhook, whose
+// payload is compatible with Slack and Mattermost incoming webhooks
+func Notify(config *Config, text string) {
+	fmt.Println("Notification:", text)
+	webhook := LiveAlerts(config).Webhook
+	if webhook == "" {
+		return
+	}
+	err := PostWebhook(webhook, text)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// PostWebhook posts a text message to a webhook
+func PostWebhook(u string, text string) error {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(u, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return errors.New("Bad status: " + resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// OnChainCoin reports whether a coin is priced on-chain rather than by the provider
+func OnChainCoin(config *Config, name string) bool {
+	for _, coin := range LiveCoins(config) {
+		if coin.Name == name {
+			return coin.Uniswap != nil || coin.Chainlink != nil
+		}
+	}
+	return false
+}
+
+// ProviderCoins returns the coins the provider prices, replacing those priced
+// on-chain with the coins they are quoted in
+func ProviderCoins(config *Config, coins []string) []string {
+	priced := []string{}
+	for _, coin := range coins {
+		if !OnChainCoin(config, coin) {
+			priced = append(priced, coin)
+		}
+	}
+	for _, coin := range LiveCoins(config) {
+		quote := ""
+		switch {
+		case coin.Uniswap != nil:
+			quote = coin.Uniswap.Quote
+		case coin.Chainlink != nil:
+			quote = coin.Chainlink.Quote
+		}
+		if quote != "" && strings.ToLower(quote) != strings.ToLower(config.Currency) {
+			priced = append(priced, quote)
+		}
+	}
+	return priced
+}
+
+// AddOnChainPrices reads the price of every coin priced on-chain into prices,
+// leaving out those that can't be read so their last known price is used
+func AddOnChainPrices(ctx context.Context, config *Config, prices PriceAPIResponse, currency string) {
+	for _, coin := range LiveCoins(config) {
+		var price float64
+		var err error
+		switch {
+		case coin.Uniswap != nil:
+			price, err = GetUniswapPrice(ctx, config, prices, *coin.Uniswap, currency)
+		case coin.Chainlink != nil:
+			price, err = GetChainlinkPrice(ctx, config, prices, *coin.Chainlink, currency)
+		default:
+			continue
+		}
+		if err != nil {
+			fmt.Println("On-chain price of", coin.Name+":", err)
+			continue
+		}
+		prices[ProviderSymbol(config, coin.Name)] = Tickers{currency: price}
+	}
+}
+
+// QuotePrice is the price in currency of the coin an on-chain price is quoted
+// in, which is 1 when it is the currency itself
+func QuotePrice(config *Config, prices PriceAPIResponse, quote string, currency string) (float64, error) {
+	if strings.ToLower(quote) == strings.ToLower(currency) {
+		return 1, nil
+	}
+	price := GetPrice(config, prices, quote, currency)
+	if price == 0 {
+		return 0, errors.New("No price for quote " + quote)
+	}
+	return price, nil
+}
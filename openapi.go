@@ -0,0 +1,24 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// OpenAPISpec is the OpenAPI 3 document describing the REST endpoints. Keep
+// openapi.json in step with the handlers, and regenerate the client package
+// from it with go generate ./client.
+//
+//go:embed openapi.json
+var OpenAPISpec []byte
+
+// GetOpenAPI serves the OpenAPI document
+func GetOpenAPI() http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Write(OpenAPISpec)
+	}
+
+	return fn
+}
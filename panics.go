@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var panicsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "portfolio_internal_panics_total",
+	Help: "Panics recovered from, by where they happened",
+}, []string{"source"})
+
+// PreparePanicMetrics registers the panic counter, starting each source at
+// zero so increases can be alerted on
+func PreparePanicMetrics() {
+	prometheus.Register(panicsTotal)
+	for _, source := range []string{"http", "update", "daily-snapshot", "config-reload", "report"} {
+		panicsTotal.WithLabelValues(source)
+	}
+}
+
+// HandlePanic counts and logs a value recovered from a panic, reporting
+// whether there was one
+func HandlePanic(source string, err interface{}) bool {
+	if err == nil {
+		return false
+	}
+	panicsTotal.WithLabelValues(source).Inc()
+	fmt.Println("Recovered from panic in", source+":", err)
+	fmt.Println(string(debug.Stack()))
+	return true
+}
+
+// Recover stops a panic from killing the process. Defer it at the top of a
+// goroutine or a unit of work.
+func Recover(source string) {
+	HandlePanic(source, recover())
+}
+
+// Recoverer is middleware answering 500 when a handler panics instead of
+// dropping the connection
+func Recoverer(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			if HandlePanic("http", err) {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
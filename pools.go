@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// getReservesSelector is the selector of getReserves() on a Uniswap v2 style pair
+	getReservesSelector = "0x0902f1ac"
+	// totalSupplySelector is the selector of the ERC-20 totalSupply()
+	totalSupplySelector = "0x18160ddd"
+)
+
+// PoolConfig is a liquidity pool position on a Uniswap v2 style pair
+type PoolConfig struct {
+	Name    string `toml:"Name"`
+	Address string `toml:"Address"`
+	// Token0 and Token1 are the coin names used to price the pair's tokens
+	Token0    string `toml:"Token0"`
+	Token1    string `toml:"Token1"`
+	Decimals0 int    `toml:"Decimals0"`
+	Decimals1 int    `toml:"Decimals1"`
+	// Liquidity is the amount of LP tokens held, used to derive the share of the pool
+	Liquidity float64 `toml:"Liquidity"`
+	// Share is the fraction of the pool held, used when Liquidity is not set
+	Share float64 `toml:"Share"`
+	// Deposit0 and Deposit1 are the token amounts originally deposited, used
+	// to compare the position against simply holding them
+	Deposit0 float64 `toml:"Deposit0"`
+	Deposit1 float64 `toml:"Deposit1"`
+}
+
+// PositionValue is the valuation of a liquidity pool position
+type PositionValue struct {
+	Name    string  `json:"name"`
+	Token0  string  `json:"token0"`
+	Token1  string  `json:"token1"`
+	Amount0 float64 `json:"amount0"`
+	Amount1 float64 `json:"amount1"`
+	Value   float64 `json:"value"`
+	// HoldValue is what the deposited tokens would be worth if they had been held
+	HoldValue float64 `json:"hold_value"`
+	// ImpermanentLoss is Value less HoldValue, negative when the pool underperforms holding
+	ImpermanentLoss float64 `json:"impermanent_loss"`
+}
+
+var poolTokenGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "pool_token_amount",
+	Help:      "Amount of an underlying token held through a liquidity pool position",
+}, []string{"pool", "token"})
+
+var poolValueGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "pool_value",
+	Help:      "Value of a liquidity pool position in the portfolio currency",
+}, []string{"pool"})
+
+var poolImpermanentLossGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "pool_impermanent_loss",
+	Help:      "Value of a liquidity pool position less the value of holding its deposits",
+}, []string{"pool"})
+
+// PreparePoolGauges registers the liquidity pool metrics when pools are configured
+func PreparePoolGauges(config *Config) {
+	if len(config.Pools) == 0 {
+		return
+	}
+	prometheus.Register(poolTokenGauge)
+	prometheus.Register(poolValueGauge)
+	prometheus.Register(poolImpermanentLossGauge)
+}
+
+// ParsePools checks each pool has what it needs to be valued
+func ParsePools(config *Config) error {
+	for _, pool := range config.Pools {
+		if pool.Address == "" || pool.Token0 == "" || pool.Token1 == "" {
+			return errors.New("Pool " + pool.Name + " needs an Address, a Token0 and a Token1")
+		}
+		if pool.Liquidity <= 0 && pool.Share <= 0 {
+			return errors.New("Pool " + pool.Name + " needs a Liquidity or a Share")
+		}
+	}
+	return nil
+}
+
+// GetPoolTokens lists the coins needed to price the configured pools
+func GetPoolTokens(conf *Config) []string {
+	tokens := []string{}
+	for _, pool := range conf.Pools {
+		tokens = append(tokens, pool.Token0, pool.Token1)
+	}
+	return tokens
+}
+
+// ValuePools reads each pool's reserves and values the position with the given
+// prices, keeping the last known value of a pool whose reserves can't be read
+func ValuePools(ctx context.Context, config *Config, prices PriceAPIResponse, currency string) []PositionValue {
+	previous := map[string]PositionValue{}
+	for _, position := range LoadPortfolio().Positions {
+		previous[position.Name] = position
+	}
+	positions := []PositionValue{}
+	for _, pool := range config.Pools {
+		amount0, amount1, err := GetPoolAmounts(ctx, config.EthereumRPC, pool)
+		if err != nil {
+			fmt.Println("Pool", pool.Name+":", err)
+			if position, ok := previous[pool.Name]; ok {
+				fmt.Println("Using last known value of pool", pool.Name)
+				positions = append(positions, position)
+			}
+			continue
+		}
+		price0 := GetPrice(config, prices, pool.Token0, currency)
+		price1 := GetPrice(config, prices, pool.Token1, currency)
+		position := PositionValue{
+			Name:      pool.Name,
+			Token0:    pool.Token0,
+			Token1:    pool.Token1,
+			Amount0:   amount0,
+			Amount1:   amount1,
+			Value:     amount0*price0 + amount1*price1,
+			HoldValue: pool.Deposit0*price0 + pool.Deposit1*price1,
+		}
+		position.ImpermanentLoss = position.Value - position.HoldValue
+
+		name := strings.ToLower(pool.Name)
+		poolTokenGauge.WithLabelValues(name, strings.ToLower(pool.Token0)).Set(amount0)
+		poolTokenGauge.WithLabelValues(name, strings.ToLower(pool.Token1)).Set(amount1)
+		poolValueGauge.WithLabelValues(name).Set(position.Value)
+		poolImpermanentLossGauge.WithLabelValues(name).Set(position.ImpermanentLoss)
+		positions = append(positions, position)
+	}
+	return positions
+}
+
+// GetPoolAmounts reads the pool reserves on-chain and returns the position's share of each token
+func GetPoolAmounts(ctx context.Context, endpoint string, pool PoolConfig) (float64, float64, error) {
+	reserves, err := EthCall(ctx, endpoint, pool.Address, getReservesSelector)
+	if err != nil {
+		return 0, 0, err
+	}
+	reserve0, err := ABIWord(reserves, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	reserve1, err := ABIWord(reserves, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	share := pool.Share
+	if pool.Liquidity > 0 {
+		supply, err := EthCall(ctx, endpoint, pool.Address, totalSupplySelector)
+		if err != nil {
+			return 0, 0, err
+		}
+		totalSupply, err := ABIWord(supply, 0)
+		if err != nil {
+			return 0, 0, err
+		}
+		if totalSupply.Sign() == 0 {
+			return 0, 0, nil
+		}
+		share = pool.Liquidity / ScaleBig(totalSupply, 18)
+	}
+
+	return share * ScaleBig(reserve0, pool.Decimals0), share * ScaleBig(reserve1, pool.Decimals1), nil
+}
+
+// GetPrice looks up a coin's price in the given currency from a provider response
+func GetPrice(config *Config, prices PriceAPIResponse, coin string, currency string) float64 {
+	for pName, psym := range prices[ProviderSymbol(config, coin)] {
+		if strings.ToLower(pName) == strings.ToLower(currency) {
+			return psym
+		}
+	}
+	return 0
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	// Registers the postgres driver with database/sql
+	_ "github.com/lib/pq"
+)
+
+// postgresSchema creates the snapshot table if it doesn't exist yet
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS snapshots (
+	time TIMESTAMPTZ NOT NULL,
+	currency TEXT NOT NULL,
+	total DOUBLE PRECISION NOT NULL,
+	coin_values JSONB NOT NULL,
+	daily BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS snapshots_time_idx ON snapshots (time);
+CREATE TABLE IF NOT EXISTS events (
+	time TIMESTAMPTZ NOT NULL,
+	kind TEXT NOT NULL,
+	text TEXT NOT NULL,
+	coin TEXT NOT NULL DEFAULT '',
+	delta DOUBLE PRECISION NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS events_time_idx ON events (time);
+`
+
+// PostgresSnapshotStore keeps snapshots in a Postgres table
+type PostgresSnapshotStore struct {
+	db *sql.DB
+}
+
+// OpenPostgresSnapshotStore connects to Postgres and creates the snapshot table if needed
+func OpenPostgresSnapshotStore(dsn string) (*PostgresSnapshotStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(postgresSchema)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &PostgresSnapshotStore{db: db}, nil
+}
+
+// Append stores a snapshot
+func (s *PostgresSnapshotStore) Append(snapshot Snapshot) error {
+	values, err := json.Marshal(snapshot.Values)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(
+		"INSERT INTO snapshots (time, currency, total, coin_values, daily) VALUES ($1, $2, $3, $4, $5)",
+		snapshot.Time, snapshot.Currency, snapshot.Total, string(values), snapshot.Daily,
+	)
+	return err
+}
+
+// Since returns the snapshots taken at or after a time, oldest first
+func (s *PostgresSnapshotStore) Since(t time.Time) ([]Snapshot, error) {
+	rows, err := s.db.Query(
+		"SELECT time, currency, total, coin_values, daily FROM snapshots WHERE time >= $1 ORDER BY time",
+		t,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := []Snapshot{}
+	for rows.Next() {
+		snapshot, err := scanSnapshot(rows)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, *snapshot)
+	}
+	return snapshots, rows.Err()
+}
+
+// Latest returns the most recent snapshot, or nil when there are none
+func (s *PostgresSnapshotStore) Latest() (*Snapshot, error) {
+	row := s.db.QueryRow("SELECT time, currency, total, coin_values, daily FROM snapshots ORDER BY time DESC LIMIT 1")
+	snapshot, err := scanSnapshot(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return snapshot, err
+}
+
+// AppendEvent stores an event
+func (s *PostgresSnapshotStore) AppendEvent(event Event) error {
+	_, err := s.db.Exec(
+		"INSERT INTO events (time, kind, text, coin, delta) VALUES ($1, $2, $3, $4, $5)",
+		event.Time, event.Kind, event.Text, event.Coin, event.Delta,
+	)
+	return err
+}
+
+// Events returns the events at or after a time, oldest first
+func (s *PostgresSnapshotStore) Events(since time.Time) ([]Event, error) {
+	rows, err := s.db.Query("SELECT time, kind, text, coin, delta FROM events WHERE time >= $1 ORDER BY time", since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		event := Event{}
+		err = rows.Scan(&event.Time, &event.Kind, &event.Text, &event.Coin, &event.Delta)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// scanner is a row that can be scanned, either *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSnapshot reads a snapshot from a row
+func scanSnapshot(row scanner) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	values := []byte{}
+	err := row.Scan(&snapshot.Time, &snapshot.Currency, &snapshot.Total, &values, &snapshot.Daily)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(values, &snapshot.Values)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Rounding modes
+const (
+	RoundHalfEven = "half-even"
+	RoundHalfUp   = "half-up"
+	RoundDown     = "down"
+	RoundUp       = "up"
+)
+
+// PrecisionConfig configures how many decimals amounts, prices and values are
+// shown with in the API, on / and in notifications
+type PrecisionConfig struct {
+	// Rounding is half-even (default), half-up, down (towards zero) or up
+	// (away from zero)
+	Rounding string `toml:"Rounding"`
+	// CoinDecimals is the default for coin amounts, defaulting to 8
+	CoinDecimals int `toml:"CoinDecimals"`
+	// FiatDecimals is the default for values in the portfolio currency,
+	// defaulting to 2
+	FiatDecimals int `toml:"FiatDecimals"`
+	// PriceDigits is the minimum number of significant digits kept in a price,
+	// so micro-cap tokens don't round to zero, defaulting to 4
+	PriceDigits int `toml:"PriceDigits"`
+	// Assets overrides the decimals for a coin or currency, e.g. BTC = 8 or JPY = 0
+	Assets map[string]int `toml:"Assets"`
+}
+
+// ParsePrecision fills in defaults for the keys missing from the config, so
+// 0 decimals can be set, and checks the rounding mode
+func ParsePrecision(conf *PrecisionConfig, meta toml.MetaData) error {
+	if conf.Rounding == "" {
+		conf.Rounding = RoundHalfEven
+	}
+	switch conf.Rounding {
+	case RoundHalfEven, RoundHalfUp, RoundDown, RoundUp:
+	default:
+		return errors.New("Unknown rounding mode: " + conf.Rounding)
+	}
+	if !meta.IsDefined("Precision", "CoinDecimals") {
+		conf.CoinDecimals = 8
+	}
+	if !meta.IsDefined("Precision", "FiatDecimals") {
+		conf.FiatDecimals = 2
+	}
+	if !meta.IsDefined("Precision", "PriceDigits") {
+		conf.PriceDigits = 4
+	}
+	return nil
+}
+
+// AmountDecimals returns the decimals an amount of coin is shown with
+func AmountDecimals(config *Config, coin string) int {
+	if decimals, ok := config.Precision.Assets[coin]; ok {
+		return decimals
+	}
+	return config.Precision.CoinDecimals
+}
+
+// ValueDecimals returns the decimals a value in currency is shown with
+func ValueDecimals(config *Config, currency string) int {
+	if decimals, ok := config.Precision.Assets[currency]; ok {
+		return decimals
+	}
+	return config.Precision.FiatDecimals
+}
+
+// PriceDecimals returns the decimals a price in currency is shown with,
+// adding decimals to small prices until PriceDigits significant digits show
+func PriceDecimals(config *Config, currency string, price float64) int {
+	decimals := ValueDecimals(config, currency)
+	if price == 0 || math.IsInf(price, 0) || math.IsNaN(price) {
+		return decimals
+	}
+	significant := config.Precision.PriceDigits - int(math.Floor(math.Log10(math.Abs(price)))) - 1
+	if significant > decimals {
+		return significant
+	}
+	return decimals
+}
+
+// Round rounds f to the given decimals with the configured rounding mode. The
+// shortest decimal representation of f is rounded, so 2.675 rounds half-up
+// to 2.68 even though the nearest float is slightly below it.
+func Round(config *Config, f float64, decimals int) float64 {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return f
+	}
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	if !ok {
+		return f
+	}
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	r.Mul(r, new(big.Rat).SetInt(scale))
+
+	num := new(big.Int).Abs(r.Num())
+	quo, rem := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+	if rem.Sign() != 0 {
+		half := new(big.Int).Lsh(rem, 1).Cmp(r.Denom())
+		switch config.Precision.Rounding {
+		case RoundUp:
+			quo.Add(quo, big.NewInt(1))
+		case RoundHalfUp:
+			if half >= 0 {
+				quo.Add(quo, big.NewInt(1))
+			}
+		case RoundDown:
+		default:
+			if half > 0 || (half == 0 && quo.Bit(0) == 1) {
+				quo.Add(quo, big.NewInt(1))
+			}
+		}
+	}
+	if r.Sign() < 0 {
+		quo.Neg(quo)
+	}
+
+	rounded, _ := new(big.Rat).SetFrac(quo, scale).Float64()
+	return rounded
+}
+
+// FormatRounded rounds f and formats it with exactly the given decimals
+func FormatRounded(config *Config, f float64, decimals int) string {
+	return strconv.FormatFloat(Round(config, f, decimals), 'f', decimals, 64)
+}
+
+// FormatValue formats a value in currency for display
+func FormatValue(config *Config, f float64, currency string) string {
+	return FormatRounded(config, f, ValueDecimals(config, currency))
+}
+
+// RoundPortfolio returns a copy of p with amounts, prices and values rounded
+// for display
+func RoundPortfolio(config *Config, p *Portfolio) *Portfolio {
+	value := func(f float64) float64 {
+		return Round(config, f, ValueDecimals(config, p.Currency))
+	}
+	rounded := *p
+	rounded.Total = value(p.Total)
+	rounded.IlliquidTotal = value(p.IlliquidTotal)
+
+	rounded.Coins = make([]CoinValue, len(p.Coins))
+	for i, coin := range p.Coins {
+		coin.Amount = Round(config, coin.Amount, AmountDecimals(config, coin.Name))
+		coin.Price = Round(config, coin.Price, PriceDecimals(config, p.Currency, coin.Price))
+		coin.Value = value(coin.Value)
+		rounded.Coins[i] = coin
+	}
+	rounded.Positions = make([]PositionValue, len(p.Positions))
+	for i, position := range p.Positions {
+		position.Amount0 = Round(config, position.Amount0, AmountDecimals(config, position.Token0))
+		position.Amount1 = Round(config, position.Amount1, AmountDecimals(config, position.Token1))
+		position.Value = value(position.Value)
+		position.HoldValue = value(position.HoldValue)
+		position.ImpermanentLoss = value(position.ImpermanentLoss)
+		rounded.Positions[i] = position
+	}
+	rounded.Illiquid = make([]NFTValue, len(p.Illiquid))
+	for i, nft := range p.Illiquid {
+		nft.FloorPrice = Round(config, nft.FloorPrice, PriceDecimals(config, p.Currency, nft.FloorPrice))
+		nft.Value = value(nft.Value)
+		rounded.Illiquid[i] = nft
+	}
+	return &rounded
+}
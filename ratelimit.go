@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Route groups that rate limits can be configured for
+const (
+	RouteGroupUI      = "ui"
+	RouteGroupAPI     = "api"
+	RouteGroupMetrics = "metrics"
+)
+
+// RateLimitConfig limits requests per client IP with a token bucket
+type RateLimitConfig struct {
+	// Rate is the sustained requests per second allowed
+	Rate float64 `toml:"Rate"`
+	// Burst is how many requests may be made at once
+	Burst int `toml:"Burst"`
+}
+
+// bucket is a client's token bucket
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter tracks a token bucket per client IP
+type RateLimiter struct {
+	limit   RateLimitConfig
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewRateLimiter returns a limiter for the given limit
+func NewRateLimiter(limit RateLimitConfig) *RateLimiter {
+	return &RateLimiter{limit: limit, buckets: map[string]*bucket{}}
+}
+
+// Allow takes a token from a client's bucket, returning how long to wait when there is none
+func (l *RateLimiter) Allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	burst := float64(l.limit.Burst)
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens = b.tokens - 1
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep forgets clients whose buckets have refilled
+func (l *RateLimiter) sweep(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	full := time.Duration(float64(l.limit.Burst) / l.limit.Rate * float64(time.Second))
+	for client, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// RateLimit is middleware limiting each client IP in a route group to the
+// configured rate, passing everything through when the group has no limit
+func RateLimit(config *Config, group string) func(next http.Handler) http.Handler {
+	limit, ok := config.RateLimits[group]
+	if !ok || limit.Rate <= 0 {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	limiter := NewRateLimiter(limit)
+	go func() {
+		for now := range time.Tick(time.Minute) {
+			limiter.sweep(now)
+		}
+	}()
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			allowed, wait := limiter.Allow(ClientIP(r), time.Now())
+			if !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// ClientIP returns the IP a request came from
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
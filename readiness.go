@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StartupRetryDelay is the wait before the first retry of the startup fetch,
+// doubling with each further attempt
+const StartupRetryDelay = 2 * time.Second
+
+// ready is set once the first valuation completes
+var ready int32
+
+// SetReady marks the portfolio as valued
+func SetReady() {
+	atomic.StoreInt32(&ready, 1)
+}
+
+// IsReady reports whether the portfolio has been valued
+func IsReady() bool {
+	return atomic.LoadInt32(&ready) == 1
+}
+
+// WarmUp runs the first update, retrying the price fetch with backoff so the
+// portfolio is valued before anything is derived from it
+func WarmUp(config *Config, coins []string, currency string, gauges map[string]prometheus.Gauge) {
+	TraceStage(context.Background(), "warm-up", func(ctx context.Context) error {
+		if IsFollower(ctx) {
+			Follow(ctx, config, gauges)
+			return nil
+		}
+		delay := StartupRetryDelay
+		var err error
+		for attempt := 1; ; attempt++ {
+			err = TraceStage(ctx, "portfolio", func(ctx context.Context) error {
+				return UpdatePortfolio(ctx, config, coins, currency, gauges)
+			})
+			if err == nil {
+				break
+			}
+			fmt.Println(err)
+			if attempt >= config.StartupRetries {
+				fmt.Println("Startup fetch failed", attempt, "times, retrying with the next update")
+				break
+			}
+			fmt.Println("Retrying startup fetch in", delay)
+			time.Sleep(delay)
+			delay = delay * 2
+		}
+		UpdateDerived(ctx, config, currency)
+		Heartbeat(config, err)
+		return err
+	})
+}
+
+// GetReady answers 200 once the portfolio has been valued and 503 before
+func GetReady() http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !IsReady() {
+			http.Error(w, "Waiting for the first valuation", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}
+
+	return fn
+}
+
+// WhenReady answers 503 until the portfolio has been valued, so scrapes never
+// see an empty portfolio
+func WhenReady(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !IsReady() {
+			http.Error(w, "Waiting for the first valuation", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// RedisLockTimeout is how long an instance may hold the fetch lock, and how
+// long the others wait for the prices it fetches
+const RedisLockTimeout = 10 * time.Second
+
+// redisNoPrice is cached for a symbol the provider returned no price for, so
+// instances waiting on the lock don't wait for it until the timeout
+const redisNoPrice = "none"
+
+// redisUnlock deletes the fetch lock only while it still holds this
+// instance's token, so an expired lock taken over by another instance isn't
+// released from under it
+var redisUnlock = redis.NewScript(1, `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`)
+
+// RedisConfig configures sharing prices and the latest valuation through Redis
+type RedisConfig struct {
+	// URL is the Redis server, e.g. redis://localhost:6379/0, the cache is off when empty
+	URL string `toml:"URL"`
+	// Prefix is prepended to every key, defaulting to portfolio-metrics:
+	Prefix string `toml:"Prefix"`
+}
+
+var redisPool *redis.Pool
+
+// OpenRedis prepares the connection pool when Redis is configured
+func OpenRedis(config *Config) *redis.Pool {
+	if config.Redis.URL == "" {
+		return nil
+	}
+	return &redis.Pool{
+		MaxIdle:     3,
+		IdleTimeout: 4 * UpdateInterval,
+		Dial: func() (redis.Conn, error) {
+			return redis.DialURL(config.Redis.URL)
+		},
+	}
+}
+
+// FetchPrices returns prices for the provider symbols, sharing them through
+// Redis when configured so several instances fetch each price once per update
+func FetchPrices(ctx context.Context, config *Config, symbols []string, currency string) (PriceAPIResponse, error) {
+	if redisPool == nil {
+		return GetPrices(ctx, symbols, currency)
+	}
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	prices, missing, err := GetCachedPrices(config, conn, symbols, currency)
+	if err != nil {
+		fmt.Println("Redis:", err)
+		return GetPrices(ctx, symbols, currency)
+	}
+	if len(missing) == 0 {
+		return prices, nil
+	}
+
+	// Only one instance fetches at a time; the others wait for its prices
+	lock := config.Redis.Prefix + "fetch:" + currency
+	token, err := RedisLockToken()
+	if err != nil {
+		return nil, err
+	}
+	_, err = redis.String(conn.Do("SET", lock, token, "NX", "PX", int64(RedisLockTimeout/time.Millisecond)))
+	if err == redis.ErrNil {
+		deadline := time.Now().Add(RedisLockTimeout)
+		for time.Now().Before(deadline) {
+			time.Sleep(250 * time.Millisecond)
+			prices, missing, err = GetCachedPrices(config, conn, symbols, currency)
+			if err != nil {
+				fmt.Println("Redis:", err)
+				return GetPrices(ctx, symbols, currency)
+			}
+			if len(missing) == 0 {
+				return prices, nil
+			}
+		}
+	} else if err == nil {
+		defer func() {
+			_, err := redisUnlock.Do(conn, lock, token)
+			if err != nil {
+				fmt.Println("Redis:", err)
+			}
+		}()
+	}
+
+	fetched, err := GetPrices(ctx, missing, currency)
+	if err != nil {
+		return nil, err
+	}
+	for _, symbol := range missing {
+		cached := redisNoPrice
+		tickers, ok := fetched[symbol]
+		if ok {
+			prices[symbol] = tickers
+			if price, ok := TickerPrice(tickers, currency); ok {
+				cached = strconv.FormatFloat(price, 'g', -1, 64)
+			}
+		}
+		_, err = conn.Do("SET", PriceKey(config, symbol, currency), cached, "PX", int64(UpdateInterval/time.Millisecond))
+		if err != nil {
+			fmt.Println("Redis:", err)
+		}
+	}
+	return prices, nil
+}
+
+// RedisLockToken returns a random token identifying this instance's hold on the fetch lock
+func RedisLockToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// TickerPrice returns the price in currency, whatever the case of the provider's key
+func TickerPrice(tickers Tickers, currency string) (float64, bool) {
+	for name, price := range tickers {
+		if strings.EqualFold(name, currency) {
+			return price, true
+		}
+	}
+	return 0, false
+}
+
+// GetCachedPrices returns the prices cached in Redis, and the symbols that
+// aren't cached. A symbol cached as having no price is in neither.
+func GetCachedPrices(config *Config, conn redis.Conn, symbols []string, currency string) (PriceAPIResponse, []string, error) {
+	prices := PriceAPIResponse{}
+	if len(symbols) == 0 {
+		return prices, nil, nil
+	}
+	keys := []interface{}{}
+	for _, symbol := range symbols {
+		keys = append(keys, PriceKey(config, symbol, currency))
+	}
+	values, err := redis.Strings(conn.Do("MGET", keys...))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	missing := []string{}
+	for i, symbol := range symbols {
+		if values[i] == redisNoPrice {
+			continue
+		}
+		price, err := strconv.ParseFloat(values[i], 64)
+		if values[i] == "" || err != nil {
+			missing = append(missing, symbol)
+			continue
+		}
+		prices[symbol] = Tickers{currency: price}
+	}
+	return prices, missing, nil
+}
+
+// PriceKey is the Redis key a symbol's price in currency is cached under
+func PriceKey(config *Config, symbol string, currency string) string {
+	return config.Redis.Prefix + "price:" + symbol + ":" + currency
+}
+
+// PublishPortfolio stores the latest valuation in Redis for other services
+func PublishPortfolio(config *Config, p *Portfolio) error {
+	if redisPool == nil {
+		return nil
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	conn := redisPool.Get()
+	defer conn.Close()
+	_, err = conn.Do("SET", config.Redis.Prefix+"portfolio:"+p.Currency, b)
+	return err
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportPDFCommand converts the HTML report to PDF, reading it from stdin
+const ReportPDFCommand = "wkhtmltopdf"
+
+// Report formats
+const (
+	ReportHTML = "html"
+	ReportPDF  = "pdf"
+)
+
+// ReportTopMovers is how many coins are listed as top movers
+const ReportTopMovers = 5
+
+// ReportConfig configures the monthly performance report
+type ReportConfig struct {
+	// Schedule renders the previous month's report every month
+	Schedule bool `toml:"Schedule"`
+	// Day and Time are the local day of the month and HH:MM it is rendered
+	// at, defaulting to the 1st at 08:00
+	Day  int    `toml:"Day"`
+	Time string `toml:"Time"`
+	// Format is html (default) or pdf, which needs wkhtmltopdf on the PATH
+	Format string `toml:"Format"`
+	// Dir is where scheduled reports are written, defaulting to reports
+	Dir string `toml:"Dir"`
+	// Template is an html/template file used instead of the built-in one
+	Template string `toml:"Template"`
+	// Email attaches scheduled reports to an email sent through [Email]
+	Email bool `toml:"Email"`
+}
+
+// Report is a month's performance, as passed to the report template
+type Report struct {
+	Currency string
+	Month    time.Time
+	// Start is the close before the month, or its first snapshot without one
+	Start float64
+	End   float64
+	// Change is End less Start, and Return is Change less Contributions
+	Change        float64
+	ChangePercent float64
+	Return        float64
+	ReturnPercent float64
+	// Contributions is what was bought or transferred in, less what was sold
+	// or transferred out
+	Contributions float64
+	Fees          float64
+	Days          []Snapshot
+	// Chart is the daily closes as SVG polyline points
+	Chart  string
+	Movers []ReportMover
+}
+
+// ReportMover is the change in value held in a coin over the month
+type ReportMover struct {
+	Coin   string
+	Start  float64
+	End    float64
+	Change float64
+}
+
+// ParseReport checks the report config and sets its defaults
+func ParseReport(config *Config) error {
+	if config.Report.Day == 0 {
+		config.Report.Day = 1
+	}
+	if config.Report.Day < 1 || config.Report.Day > 28 {
+		return errors.New("Report.Day must be between 1 and 28")
+	}
+	if config.Report.Time == "" {
+		config.Report.Time = "08:00"
+	}
+	if config.Report.Format == "" {
+		config.Report.Format = ReportHTML
+	}
+	if config.Report.Format != ReportHTML && config.Report.Format != ReportPDF {
+		return errors.New("Unsupported report format: " + config.Report.Format)
+	}
+	if config.Report.Dir == "" {
+		config.Report.Dir = "reports"
+	}
+	return nil
+}
+
+// RunReport renders the report of a month to a file or stdout, optionally emailing it
+func RunReport(config *Config, args []string) error {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	month := flags.String("month", "", "month to report, as YYYY-MM, defaulting to the previous month")
+	format := flags.String("format", config.Report.Format, "report format, html or pdf")
+	out := flags.String("out", "", "file to write the report to instead of stdout")
+	email := flags.Bool("email", false, "email the report through [Email]")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	start := PreviousMonth(time.Now(), config.Location)
+	if *month != "" {
+		start, err = time.ParseInLocation("2006-01", *month, config.Location)
+		if err != nil {
+			return errors.New("Bad month, expected YYYY-MM: " + *month)
+		}
+	}
+	store, err := OpenSnapshotStore(config)
+	if err != nil {
+		return err
+	}
+	report, err := BuildReport(config, store, start)
+	if err != nil {
+		return err
+	}
+	b, err := RenderReport(config, report, *format)
+	if err != nil {
+		return err
+	}
+
+	if *email {
+		err = EmailReport(config, report, *format, b)
+		if err != nil {
+			return err
+		}
+	}
+	if *out != "" {
+		return ioutil.WriteFile(*out, b, 0644)
+	}
+	if !*email {
+		_, err = os.Stdout.Write(b)
+	}
+	return err
+}
+
+// PreviousMonth returns the first day of the month before now's
+func PreviousMonth(now time.Time, loc *time.Location) time.Time {
+	local := now.In(loc)
+	return time.Date(local.Year(), local.Month()-1, 1, 0, 0, 0, 0, loc)
+}
+
+// BuildReport summarises a month starting at start from the snapshot history and the ledger
+func BuildReport(config *Config, store SnapshotStore, start time.Time) (*Report, error) {
+	end := start.AddDate(0, 1, 0)
+	snapshots, err := store.Since(start.AddDate(0, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	before := []Snapshot{}
+	during := []Snapshot{}
+	for _, snapshot := range snapshots {
+		if snapshot.Currency != config.Currency || !snapshot.Time.Before(end) {
+			continue
+		}
+		if snapshot.Time.Before(start) {
+			before = append(before, snapshot)
+		} else {
+			during = append(during, snapshot)
+		}
+	}
+	days := DailySeries(during, config.Location)
+	if len(days) == 0 {
+		return nil, errors.New("No snapshots in " + start.Format("January 2006"))
+	}
+	first := days[0]
+	if len(before) > 0 {
+		first = before[len(before)-1]
+	}
+	last := days[len(days)-1]
+
+	report := &Report{
+		Currency: config.Currency,
+		Month:    start,
+		Start:    first.Total,
+		End:      last.Total,
+		Change:   last.Total - first.Total,
+		Days:     days,
+		Chart:    ReportChart(days, 600, 200),
+		Movers:   ReportMovers(first, last),
+	}
+
+	txs, err := LoadLedger(config.Ledger)
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range txs {
+		if tx.Time.Before(start) || !tx.Time.Before(end) {
+			continue
+		}
+		switch {
+		case tx.Type == TransactionIncome:
+		case tx.Acquires():
+			report.Contributions = report.Contributions + tx.Value
+		case tx.Disposes():
+			report.Contributions = report.Contributions - tx.Value
+		}
+	}
+	for _, fee := range BuildBook(txs, config.LotMethod, config.TaxableOuts).Fees {
+		if !fee.Time.Before(start) && fee.Time.Before(end) {
+			report.Fees = report.Fees + fee.Value
+		}
+	}
+
+	report.Return = report.Change - report.Contributions
+	if report.Start != 0 {
+		report.ChangePercent = report.Change / report.Start * 100
+		report.ReturnPercent = report.Return / report.Start * 100
+	}
+	return report, nil
+}
+
+// ReportMovers ranks the coins by the absolute change in value held between two snapshots
+func ReportMovers(first Snapshot, last Snapshot) []ReportMover {
+	coins := map[string]bool{}
+	for coin := range first.Values {
+		coins[coin] = true
+	}
+	for coin := range last.Values {
+		coins[coin] = true
+	}
+	movers := []ReportMover{}
+	for coin := range coins {
+		movers = append(movers, ReportMover{
+			Coin:   coin,
+			Start:  first.Values[coin],
+			End:    last.Values[coin],
+			Change: last.Values[coin] - first.Values[coin],
+		})
+	}
+	sort.Slice(movers, func(i, j int) bool {
+		return math.Abs(movers[i].Change) > math.Abs(movers[j].Change)
+	})
+	if len(movers) > ReportTopMovers {
+		movers = movers[:ReportTopMovers]
+	}
+	return movers
+}
+
+// ReportChart scales the daily totals to SVG polyline points in a width by height box
+func ReportChart(days []Snapshot, width float64, height float64) string {
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, day := range days {
+		low = math.Min(low, day.Total)
+		high = math.Max(high, day.Total)
+	}
+	points := []string{}
+	for i, day := range days {
+		x := 0.0
+		if len(days) > 1 {
+			x = float64(i) / float64(len(days)-1) * width
+		}
+		y := height / 2
+		if high > low {
+			y = height - (day.Total-low)/(high-low)*height
+		}
+		points = append(points, strconv.FormatFloat(x, 'f', 1, 64)+","+strconv.FormatFloat(y, 'f', 1, 64))
+	}
+	return strings.Join(points, " ")
+}
+
+// RenderReport renders a report as HTML from the template, converting it to PDF when asked
+func RenderReport(config *Config, report *Report, format string) ([]byte, error) {
+	text := reportTemplate
+	if config.Report.Template != "" {
+		b, err := ioutil.ReadFile(config.Report.Template)
+		if err != nil {
+			return nil, err
+		}
+		text = string(b)
+	}
+	tmpl, err := template.New("report").Funcs(template.FuncMap{
+		"value": func(f float64) string {
+			return FormatValue(config, f, report.Currency)
+		},
+		"percent": func(f float64) string {
+			return fmt.Sprintf("%+.2f%%", f)
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	err = tmpl.Execute(buf, report)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case ReportHTML:
+		return buf.Bytes(), nil
+	case ReportPDF:
+		return ConvertToPDF(buf.Bytes())
+	}
+	return nil, errors.New("Unsupported report format: " + format)
+}
+
+// ConvertToPDF converts an HTML document to PDF with wkhtmltopdf
+func ConvertToPDF(html []byte) ([]byte, error) {
+	b, err := RunFilter(html, ReportPDFCommand, "--quiet", "-", "-")
+	if err != nil {
+		return nil, errors.New("Could not convert report to PDF: " + err.Error())
+	}
+	return b, nil
+}
+
+// EmailReport sends a rendered report as an attachment
+func EmailReport(config *Config, report *Report, format string, b []byte) error {
+	contentType := "text/html; charset=utf-8"
+	if format == ReportPDF {
+		contentType = "application/pdf"
+	}
+	title := "Portfolio report for " + report.Month.Format("January 2006")
+	body := title + "\n\n" +
+		"Value: " + FormatValue(config, report.End, report.Currency) + " " + report.Currency + "\n" +
+		"Change: " + FormatValue(config, report.Change, report.Currency) + fmt.Sprintf(" (%+.2f%%)", report.ChangePercent) + "\n" +
+		"Return excluding contributions: " + FormatValue(config, report.Return, report.Currency) + fmt.Sprintf(" (%+.2f%%)", report.ReturnPercent) + "\n"
+	return SendEmail(config.Email, title, body, Attachment{
+		Name:        ReportFileName(report, format),
+		ContentType: contentType,
+		Data:        b,
+	})
+}
+
+// ReportFileName is the file a month's report is written to
+func ReportFileName(report *Report, format string) string {
+	return "portfolio-" + report.Month.Format("2006-01") + "." + format
+}
+
+// NextReport returns the next time after now the monthly report is due
+func NextReport(now time.Time, day int, hour int, minute int, loc *time.Location) time.Time {
+	local := now.In(loc)
+	next := time.Date(local.Year(), local.Month(), day, hour, minute, 0, 0, loc)
+	if !next.After(now) {
+		next = time.Date(local.Year(), local.Month()+1, day, hour, minute, 0, 0, loc)
+	}
+	return next
+}
+
+// StartReports renders the previous month's report at the configured day and
+// time each month, writing it to the report directory and emailing it when configured
+func StartReports(config *Config) {
+	if !config.Report.Schedule {
+		return
+	}
+	hour, minute, err := ParseDailySnapshotTime(config.Report.Time)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	go func() {
+		for {
+			next := NextReport(time.Now(), config.Report.Day, hour, minute, config.Location)
+			time.Sleep(time.Until(next))
+			if elector != nil && !elector.IsLeader() {
+				continue
+			}
+			func() {
+				defer Recover("report")
+				err := WriteScheduledReport(config, PreviousMonth(next, config.Location))
+				if err != nil {
+					fmt.Println("Report:", err)
+				}
+			}()
+		}
+	}()
+}
+
+// WriteScheduledReport renders a month's report into the report directory,
+// emailing it when configured
+func WriteScheduledReport(config *Config, month time.Time) error {
+	report, err := BuildReport(config, snapshotStore, month)
+	if err != nil {
+		return err
+	}
+	b, err := RenderReport(config, report, config.Report.Format)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(config.Report.Dir, 0755)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(config.Report.Dir, ReportFileName(report, config.Report.Format)), b, 0644)
+	if err != nil {
+		return err
+	}
+	if config.Report.Email {
+		return EmailReport(config, report, config.Report.Format, b)
+	}
+	return nil
+}
+
+// reportTemplate is the built-in report template
+const reportTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Portfolio report for {{.Month.Format "January 2006"}}</title>
+<style>
+body { font-family: sans-serif; max-width: 640px; margin: 2em auto; color: #222; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 1.5em; }
+td, th { padding: 4px 8px; border-bottom: 1px solid #ddd; text-align: right; }
+td:first-child, th:first-child { text-align: left; }
+svg { border: 1px solid #ddd; margin-bottom: 1.5em; }
+</style>
+</head>
+<body>
+<h1>Portfolio report for {{.Month.Format "January 2006"}}</h1>
+
+<h2>Value</h2>
+<svg width="600" height="200" viewBox="0 0 600 200" preserveAspectRatio="none">
+<polyline points="{{.Chart}}" fill="none" stroke="#1f77b4" stroke-width="2"/>
+</svg>
+<table>
+<tr><td>Start</td><td>{{value .Start}} {{.Currency}}</td></tr>
+<tr><td>End</td><td>{{value .End}} {{.Currency}}</td></tr>
+<tr><td>Change</td><td>{{value .Change}} ({{percent .ChangePercent}})</td></tr>
+</table>
+
+<h2>Returns</h2>
+<table>
+<tr><td>Contributions</td><td>{{value .Contributions}}</td></tr>
+<tr><td>Return excluding contributions</td><td>{{value .Return}} ({{percent .ReturnPercent}})</td></tr>
+<tr><td>Fees paid</td><td>{{value .Fees}}</td></tr>
+</table>
+
+{{if .Movers}}
+<h2>Top movers</h2>
+<table>
+<tr><th>Coin</th><th>Start</th><th>End</th><th>Change</th></tr>
+{{range .Movers}}<tr><td>{{.Coin}}</td><td>{{value .Start}}</td><td>{{value .End}}</td><td>{{value .Change}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`
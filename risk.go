@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RiskWindows are the rolling windows risk-adjusted returns are computed over
+var RiskWindows = map[string]time.Duration{
+	"30d":  30 * 24 * time.Hour,
+	"90d":  90 * 24 * time.Hour,
+	"365d": 365 * 24 * time.Hour,
+}
+
+var sharpeGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "sharpe_ratio",
+	Help:      "Annualised Sharpe ratio of daily portfolio returns over a rolling window",
+}, []string{"window"})
+
+var sortinoGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "sortino_ratio",
+	Help:      "Annualised Sortino ratio of daily portfolio returns over a rolling window",
+}, []string{"window"})
+
+// PrepareRiskGauges registers the risk-adjusted return metrics
+func PrepareRiskGauges() {
+	prometheus.Register(sharpeGauge)
+	prometheus.Register(sortinoGauge)
+}
+
+// UpdateRisk computes risk-adjusted returns from the daily series in the snapshot history
+func UpdateRisk(config *Config) {
+	recent, err := RecentSnapshots()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	now := time.Now()
+	for window, length := range RiskWindows {
+		returns := DailyReturns(DailySeries(SnapshotsSince(recent, now.Add(-length)), config.Location))
+		if len(returns) < 2 {
+			continue
+		}
+		sharpe, sortino := RiskRatios(returns, config.RiskFreeRate)
+		sharpeGauge.WithLabelValues(window).Set(sharpe)
+		sortinoGauge.WithLabelValues(window).Set(sortino)
+	}
+}
+
+// DailyReturns converts daily closes into fractional returns
+func DailyReturns(closes []Snapshot) []float64 {
+	returns := []float64{}
+	for i := 1; i < len(closes); i++ {
+		if closes[i-1].Total == 0 {
+			continue
+		}
+		returns = append(returns, closes[i].Total/closes[i-1].Total-1)
+	}
+	return returns
+}
+
+// RiskRatios computes the annualised Sharpe and Sortino ratios of daily
+// returns against an annual risk-free rate. Crypto trades every day, so
+// returns are annualised over 365 days.
+func RiskRatios(returns []float64, riskFreeRate float64) (float64, float64) {
+	dailyRiskFree := riskFreeRate / 365
+	mean := 0.0
+	for _, r := range returns {
+		mean = mean + r - dailyRiskFree
+	}
+	mean = mean / float64(len(returns))
+
+	variance, downside := 0.0, 0.0
+	for _, r := range returns {
+		excess := r - dailyRiskFree
+		variance = variance + (excess-mean)*(excess-mean)
+		if excess < 0 {
+			downside = downside + excess*excess
+		}
+	}
+	deviation := math.Sqrt(variance / float64(len(returns)-1))
+	downsideDeviation := math.Sqrt(downside / float64(len(returns)))
+
+	annualise := math.Sqrt(365)
+	sharpe, sortino := 0.0, 0.0
+	if deviation > 0 {
+		sharpe = mean / deviation * annualise
+	}
+	if downsideDeviation > 0 {
+		sortino = mean / downsideDeviation * annualise
+	}
+	return sharpe, sortino
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	// SheetsScope is the OAuth scope needed to append to a spreadsheet
+	SheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+	// SheetsAPIURL is the Google Sheets API endpoint
+	SheetsAPIURL = "https://sheets.googleapis.com/v4/spreadsheets/"
+	// SheetsTimeout bounds each request to the Sheets API and for access tokens
+	SheetsTimeout = 30 * time.Second
+)
+
+// SheetsConfig configures appending snapshots to a Google Sheet as a
+// service account, which the sheet must be shared with
+type SheetsConfig struct {
+	// Credentials is the path of the service account's JSON key, the export is off when empty
+	Credentials string `toml:"Credentials"`
+	// SpreadsheetID is the ID in the sheet's URL
+	SpreadsheetID string `toml:"SpreadsheetID"`
+	// Sheet is the name of the tab rows are appended to, defaulting to Sheet1
+	Sheet string `toml:"Sheet"`
+	// Daily appends only end-of-day snapshots rather than every snapshot
+	Daily bool `toml:"Daily"`
+}
+
+// sheetsRows queues rows for the export goroutine, which appends them in
+// order so a slow or failing Sheets API never holds up recording snapshots
+var sheetsRows = make(chan []interface{}, 100)
+var sheetsExport sync.Once
+
+// sheetsClient authorizes requests as the service account, refreshing its
+// access token as it expires. Only the export goroutine uses it.
+var sheetsClient *http.Client
+
+// ExportSnapshot queues a snapshot to be appended to the configured Google
+// Sheet, logging it when the queue is full
+func ExportSnapshot(config *Config, snapshot Snapshot) {
+	if config.Sheets.Credentials == "" || (config.Sheets.Daily && !snapshot.Daily) {
+		return
+	}
+	sheetsExport.Do(func() {
+		go AppendSheetRows(config.Sheets)
+	})
+	select {
+	case sheetsRows <- SnapshotRow(config, snapshot):
+	default:
+		fmt.Println("Google Sheets: export queue full, skipping snapshot at", snapshot.Time)
+	}
+}
+
+// AppendSheetRows appends queued rows one at a time, logging any failure
+func AppendSheetRows(config SheetsConfig) {
+	for row := range sheetsRows {
+		err := AppendSheetRow(config, row)
+		if err != nil {
+			fmt.Println("Google Sheets:", err)
+		}
+	}
+}
+
+// SnapshotRow lays out a snapshot as its local time, currency, total and the
+// value of each configured coin in config order
+func SnapshotRow(config *Config, snapshot Snapshot) []interface{} {
+	row := []interface{}{
+		snapshot.Time.In(config.Location).Format("2006-01-02 15:04:05"),
+		snapshot.Currency,
+		Round(config, snapshot.Total, ValueDecimals(config, snapshot.Currency)),
+	}
+	for _, coin := range GetCoins(config) {
+		row = append(row, Round(config, snapshot.Values[coin], ValueDecimals(config, snapshot.Currency)))
+	}
+	return row
+}
+
+// AppendSheetRow appends a row after the last one in the sheet
+func AppendSheetRow(config SheetsConfig, row []interface{}) error {
+	client, err := SheetsClient(config.Credentials)
+	if err != nil {
+		return err
+	}
+	sheet := config.Sheet
+	if sheet == "" {
+		sheet = "Sheet1"
+	}
+	body, err := json.Marshal(map[string]interface{}{"values": [][]interface{}{row}})
+	if err != nil {
+		return err
+	}
+	u := SheetsAPIURL + url.PathEscape(config.SpreadsheetID) + "/values/" + url.PathEscape(sheet) +
+		":append?valueInputOption=USER_ENTERED&insertDataOption=INSERT_ROWS"
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return errors.New("Bad status: " + resp.Status)
+	}
+	return nil
+}
+
+// SheetsClient returns an HTTP client authorized as the service account in
+// the credentials JSON key
+func SheetsClient(credentials string) (*http.Client, error) {
+	if sheetsClient != nil {
+		return sheetsClient, nil
+	}
+	b, err := ioutil.ReadFile(credentials)
+	if err != nil {
+		return nil, err
+	}
+	jwt, err := google.JWTConfigFromJSON(b, SheetsScope)
+	if err != nil {
+		return nil, err
+	}
+	// Token requests use a client with the same timeout as the API requests
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Timeout: SheetsTimeout})
+	client := jwt.Client(ctx)
+	client.Timeout = SheetsTimeout
+	sheetsClient = client
+	return sheetsClient, nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Snapshot is the valuation of the portfolio at a point in time
+type Snapshot struct {
+	Time     time.Time `json:"time"`
+	Currency string    `json:"currency"`
+	Total    float64   `json:"total"`
+	// Values is the value held in each coin
+	Values map[string]float64 `json:"values"`
+	// Daily marks an explicit end-of-day snapshot
+	Daily bool `json:"daily,omitempty"`
+}
+
+// SnapshotStore persists the snapshot history
+type SnapshotStore interface {
+	// Append stores a snapshot
+	Append(snapshot Snapshot) error
+	// Since returns the snapshots taken at or after a time, oldest first
+	Since(t time.Time) ([]Snapshot, error)
+	// Latest returns the most recent snapshot, or nil when there are none
+	Latest() (*Snapshot, error)
+	// AppendEvent stores an event
+	AppendEvent(event Event) error
+	// Events returns the events at or after a time, oldest first
+	Events(since time.Time) ([]Event, error)
+}
+
+// FileSnapshotStore keeps snapshots in memory, appending them to a JSON lines
+// file, and appends events to a second file
+type FileSnapshotStore struct {
+	path       string
+	eventsPath string
+	mu         sync.RWMutex
+	snapshots  []Snapshot
+}
+
+var snapshotStore SnapshotStore
+
+// OpenSnapshotStore opens the Postgres store when a DSN is configured and the file store otherwise
+func OpenSnapshotStore(config *Config) (SnapshotStore, error) {
+	if config.SnapshotDSN != "" {
+		return OpenPostgresSnapshotStore(config.SnapshotDSN)
+	}
+	return OpenFileSnapshotStore(config.Snapshots, config.Events)
+}
+
+// OpenFileSnapshotStore loads the snapshots in a file, which may not exist yet
+func OpenFileSnapshotStore(path string, eventsPath string) (*FileSnapshotStore, error) {
+	store := &FileSnapshotStore{path: path, eventsPath: eventsPath, snapshots: []Snapshot{}}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		snapshot := Snapshot{}
+		err = json.Unmarshal(scanner.Bytes(), &snapshot)
+		if err != nil {
+			return nil, err
+		}
+		store.snapshots = append(store.snapshots, snapshot)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(store.snapshots, func(i, j int) bool {
+		return store.snapshots[i].Time.Before(store.snapshots[j].Time)
+	})
+	return store, nil
+}
+
+// Append stores a snapshot
+func (s *FileSnapshotStore) Append(snapshot Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	if err != nil {
+		return err
+	}
+
+	i := sort.Search(len(s.snapshots), func(i int) bool {
+		return s.snapshots[i].Time.After(snapshot.Time)
+	})
+	s.snapshots = append(s.snapshots, Snapshot{})
+	copy(s.snapshots[i+1:], s.snapshots[i:])
+	s.snapshots[i] = snapshot
+	return nil
+}
+
+// Since returns the snapshots taken at or after a time, oldest first
+func (s *FileSnapshotStore) Since(t time.Time) ([]Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := sort.Search(len(s.snapshots), func(i int) bool {
+		return !s.snapshots[i].Time.Before(t)
+	})
+	return append([]Snapshot{}, s.snapshots[i:]...), nil
+}
+
+// Latest returns the most recent snapshot, or nil when there are none
+func (s *FileSnapshotStore) Latest() (*Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.snapshots) == 0 {
+		return nil, nil
+	}
+	latest := s.snapshots[len(s.snapshots)-1]
+	return &latest, nil
+}
+
+// AppendEvent stores an event
+func (s *FileSnapshotStore) AppendEvent(event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.eventsPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+// Events returns the events at or after a time, oldest first
+func (s *FileSnapshotStore) Events(since time.Time) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := []Event{}
+	f, err := os.Open(s.eventsPath)
+	if os.IsNotExist(err) {
+		return events, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		event := Event{}
+		err = json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil {
+			return nil, err
+		}
+		if !event.Time.Before(since) {
+			events = append(events, event)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events, nil
+}
+
+// NewSnapshot captures a valuation as a snapshot
+func NewSnapshot(p *Portfolio) Snapshot {
+	snapshot := Snapshot{Time: p.UpdatedAt, Currency: p.Currency, Total: p.Total, Values: map[string]float64{}}
+	for _, value := range p.Coins {
+		snapshot.Values[value.Name] = value.Value
+	}
+	for _, position := range p.Positions {
+		snapshot.Values[position.Name] = position.Value
+	}
+	return snapshot
+}
+
+// RecordSnapshot stores the latest valuation once the snapshot interval has passed
+func RecordSnapshot(config *Config) error {
+	p := LoadPortfolio()
+	if p.UpdatedAt.IsZero() {
+		return nil
+	}
+	latest, err := snapshotStore.Latest()
+	if err != nil {
+		return err
+	}
+	if latest != nil && p.UpdatedAt.Sub(latest.Time) < config.SnapshotInterval.Duration {
+		return nil
+	}
+	snapshot := NewSnapshot(p)
+	err = snapshotStore.Append(snapshot)
+	if err != nil {
+		return err
+	}
+	ExportSnapshot(config, snapshot)
+	return nil
+}
+
+// RecentHistory is the longest window the derived metrics read from the snapshot history
+const RecentHistory = 365 * 24 * time.Hour
+
+// recentSnapshots caches the last RecentHistory of snapshots for the derived
+// metrics, which would otherwise query the store for each of their windows
+var recentSnapshots struct {
+	mu        sync.Mutex
+	loaded    bool
+	latest    time.Time
+	snapshots []Snapshot
+}
+
+// RecentSnapshots returns the snapshots of the last RecentHistory, oldest
+// first. They are only read from the store again once a newer snapshot has
+// been recorded, by this replica or another.
+func RecentSnapshots() ([]Snapshot, error) {
+	recentSnapshots.mu.Lock()
+	defer recentSnapshots.mu.Unlock()
+
+	latest, err := snapshotStore.Latest()
+	if err != nil {
+		return nil, err
+	}
+	at := time.Time{}
+	if latest != nil {
+		at = latest.Time
+	}
+	if !recentSnapshots.loaded || !at.Equal(recentSnapshots.latest) {
+		snapshots, err := snapshotStore.Since(time.Now().Add(-RecentHistory))
+		if err != nil {
+			return nil, err
+		}
+		recentSnapshots.snapshots = snapshots
+		recentSnapshots.latest = at
+		recentSnapshots.loaded = true
+	}
+	return recentSnapshots.snapshots, nil
+}
+
+// SnapshotsSince returns the snapshots, sorted oldest first, taken at or after a time
+func SnapshotsSince(snapshots []Snapshot, t time.Time) []Snapshot {
+	i := sort.Search(len(snapshots), func(i int) bool {
+		return !snapshots[i].Time.Before(t)
+	})
+	return snapshots[i:]
+}
+
+// DailyCloses returns the last snapshot of each day, oldest first
+func DailyCloses(snapshots []Snapshot, loc *time.Location) []Snapshot {
+	closes := []Snapshot{}
+	for _, snapshot := range snapshots {
+		if len(closes) > 0 && SameDay(closes[len(closes)-1].Time, snapshot.Time, loc) {
+			closes[len(closes)-1] = snapshot
+			continue
+		}
+		closes = append(closes, snapshot)
+	}
+	return closes
+}
+
+// SameDay reports whether two times fall on the same day in a location
+func SameDay(a time.Time, b time.Time, loc *time.Location) bool {
+	ay, am, ad := a.In(loc).Date()
+	by, bm, bd := b.In(loc).Date()
+	return ay == by && am == bm && ad == bd
+}
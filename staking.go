@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SolanaStakeProgram is the address of the Solana stake program
+const SolanaStakeProgram = "Stake11111111111111111111111111111111111111"
+
+// solanaStakerOffset is the offset of the staker authority in a stake account
+const solanaStakerOffset = 12
+
+// StakingConfig is an address whose native staking balance is merged into the holdings
+type StakingConfig struct {
+	// Chain is either "cosmos" or "solana"
+	Chain string `toml:"Chain"`
+	// Endpoint is the chain's REST API for cosmos, or its JSON-RPC endpoint for solana
+	Endpoint string `toml:"Endpoint"`
+	Address  string `toml:"Address"`
+	// Coin is the configured coin the balance is added to
+	Coin string `toml:"Coin"`
+	// Denom is the cosmos staking denomination, defaulting to "u" followed by the coin
+	Denom string `toml:"Denom"`
+	// Decimals is the precision of the base denomination, defaulting to 6 for cosmos and 9 for solana
+	Decimals int `toml:"Decimals"`
+}
+
+// StakedBalance is the staked amount and pending rewards of an address
+type StakedBalance struct {
+	Staked  float64
+	Rewards float64
+}
+
+var stakedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "staked_amount",
+	Help:      "Amount of a coin delegated to validators by an address",
+}, []string{"chain", "address", "coin"})
+
+var stakingRewardsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "staking_pending_rewards",
+	Help:      "Staking rewards accrued by an address but not yet claimed",
+}, []string{"chain", "address", "coin"})
+
+var stakedBalances = map[string]StakedBalance{}
+
+// PrepareStakingGauges registers the staking metrics when staking addresses are configured
+func PrepareStakingGauges(config *Config) {
+	if len(config.Staking) == 0 {
+		return
+	}
+	prometheus.Register(stakedGauge)
+	prometheus.Register(stakingRewardsGauge)
+}
+
+// GetStakedAmounts reads every configured staking address and returns the staked
+// amount plus pending rewards per coin, and the pending rewards alone. An
+// address that can't be read keeps its last known balance.
+func GetStakedAmounts(ctx context.Context, config *Config) (map[string]float64, map[string]float64) {
+	amounts := map[string]float64{}
+	rewards := map[string]float64{}
+	for _, staking := range config.Staking {
+		key := staking.Chain + "/" + staking.Address
+		balance, err := GetStakedBalance(ctx, staking)
+		if err != nil {
+			fmt.Println("Staking", key+":", err)
+			last, ok := stakedBalances[key]
+			if !ok {
+				continue
+			}
+			balance = &last
+		}
+		stakedBalances[key] = *balance
+
+		labels := []string{strings.ToLower(staking.Chain), staking.Address, strings.ToLower(staking.Coin)}
+		stakedGauge.WithLabelValues(labels...).Set(balance.Staked)
+		stakingRewardsGauge.WithLabelValues(labels...).Set(balance.Rewards)
+		amounts[staking.Coin] = amounts[staking.Coin] + balance.Staked + balance.Rewards
+		rewards[staking.Coin] = rewards[staking.Coin] + balance.Rewards
+	}
+	return amounts, rewards
+}
+
+// GetStakedBalance reads the staked balance of an address from its chain
+func GetStakedBalance(ctx context.Context, staking StakingConfig) (*StakedBalance, error) {
+	switch strings.ToLower(staking.Chain) {
+	case "cosmos":
+		return GetCosmosStakedBalance(ctx, staking)
+	case "solana":
+		return GetSolanaStakedBalance(ctx, staking)
+	}
+	return nil, errors.New("Unsupported staking chain: " + staking.Chain)
+}
+
+// GetCosmosStakedBalance reads delegations and pending rewards from a Cosmos SDK REST API
+func GetCosmosStakedBalance(ctx context.Context, staking StakingConfig) (*StakedBalance, error) {
+	denom := staking.Denom
+	if denom == "" {
+		denom = "u" + strings.ToLower(staking.Coin)
+	}
+	decimals := staking.Decimals
+	if decimals == 0 {
+		decimals = 6
+	}
+	endpoint := strings.TrimSuffix(staking.Endpoint, "/")
+	address := url.PathEscape(staking.Address)
+
+	delegations := CosmosDelegationsAPIResponse{}
+	err := GetJSON(ctx, endpoint+"/cosmos/staking/v1beta1/delegations/"+address, &delegations)
+	if err != nil {
+		return nil, err
+	}
+	rewards := CosmosRewardsAPIResponse{}
+	err = GetJSON(ctx, endpoint+"/cosmos/distribution/v1beta1/delegators/"+address+"/rewards", &rewards)
+	if err != nil {
+		return nil, err
+	}
+
+	balance := &StakedBalance{}
+	for _, delegation := range delegations.DelegationResponses {
+		if delegation.Balance.Denom != denom {
+			continue
+		}
+		amount, err := strconv.ParseFloat(delegation.Balance.Amount, 64)
+		if err != nil {
+			return nil, err
+		}
+		balance.Staked = balance.Staked + amount
+	}
+	for _, reward := range rewards.Total {
+		if reward.Denom != denom {
+			continue
+		}
+		amount, err := strconv.ParseFloat(reward.Amount, 64)
+		if err != nil {
+			return nil, err
+		}
+		balance.Rewards = balance.Rewards + amount
+	}
+	balance.Staked = balance.Staked / math.Pow10(decimals)
+	balance.Rewards = balance.Rewards / math.Pow10(decimals)
+	return balance, nil
+}
+
+// GetSolanaStakedBalance sums the stake accounts an address is the staker of.
+// Solana compounds rewards into the stake account, so there are never pending rewards.
+func GetSolanaStakedBalance(ctx context.Context, staking StakingConfig) (*StakedBalance, error) {
+	decimals := staking.Decimals
+	if decimals == 0 {
+		decimals = 9
+	}
+	options := map[string]interface{}{
+		"encoding":  "base64",
+		"dataSlice": map[string]int{"offset": 0, "length": 0},
+		"filters": []interface{}{
+			map[string]interface{}{
+				"memcmp": map[string]interface{}{"offset": solanaStakerOffset, "bytes": staking.Address},
+			},
+		},
+	}
+	accounts := []struct {
+		Account struct {
+			Lamports uint64 `json:"lamports"`
+		} `json:"account"`
+	}{}
+	err := CallRPC(ctx, staking.Endpoint, "getProgramAccounts", []interface{}{SolanaStakeProgram, options}, &accounts)
+	if err != nil {
+		return nil, err
+	}
+
+	lamports := new(big.Int)
+	for _, account := range accounts {
+		lamports.Add(lamports, new(big.Int).SetUint64(account.Account.Lamports))
+	}
+	return &StakedBalance{Staked: ScaleBig(lamports, decimals)}, nil
+}
+
+// CosmosDelegationsAPIResponse is the JSON response from the Cosmos staking delegations API
+type CosmosDelegationsAPIResponse struct {
+	DelegationResponses []struct {
+		Balance CosmosCoin `json:"balance"`
+	} `json:"delegation_responses"`
+}
+
+// CosmosRewardsAPIResponse is the JSON response from the Cosmos distribution rewards API
+type CosmosRewardsAPIResponse struct {
+	Total []CosmosCoin `json:"total"`
+}
+
+// CosmosCoin is an amount of a Cosmos SDK denomination
+type CosmosCoin struct {
+	Denom  string `json:"denom"`
+	Amount string `json:"amount"`
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// TaxYear is the realized gains of the disposals in a tax year
+type TaxYear struct {
+	Year      int        `json:"year"`
+	Start     time.Time  `json:"start"`
+	Proceeds  float64    `json:"proceeds"`
+	Cost      float64    `json:"cost"`
+	Gain      float64    `json:"gain"`
+	Disposals []Disposal `json:"disposals"`
+}
+
+// TaxReport is the realized gains per tax year using a lot matching method
+type TaxReport struct {
+	Currency string     `json:"currency"`
+	Method   string     `json:"method"`
+	Years    []*TaxYear `json:"years"`
+}
+
+// RunTaxReport computes the realized gains in the ledger per tax year and writes them as CSV or JSON
+func RunTaxReport(config *Config, args []string) error {
+	flags := flag.NewFlagSet("tax-report", flag.ContinueOnError)
+	method := flags.String("method", config.LotMethod, "lot matching method, fifo, lifo or hifo")
+	format := flags.String("format", "csv", "report format, csv or json")
+	yearStart := flags.String("year-start", "01-01", "month and day the tax year starts on")
+	year := flags.Int("year", 0, "only report the tax year starting in this year")
+	out := flags.String("out", "", "file to write the report to instead of stdout")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *method != MethodFIFO && *method != MethodLIFO && *method != MethodHIFO {
+		return errors.New("Unsupported lot matching method: " + *method)
+	}
+	start, err := time.Parse("01-02", *yearStart)
+	if err != nil {
+		return errors.New("Bad tax year start, expected MM-DD: " + *yearStart)
+	}
+
+	txs, err := LoadLedger(config.Ledger)
+	if err != nil {
+		return err
+	}
+	book := BuildBook(txs, *method, config.TaxableOuts)
+	PrintShortfalls(book)
+	report := BuildTaxReport(book, config.Currency, start.Month(), start.Day())
+	if *year != 0 {
+		years := []*TaxYear{}
+		for _, taxYear := range report.Years {
+			if taxYear.Year == *year {
+				years = append(years, taxYear)
+			}
+		}
+		report.Years = years
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	switch *format {
+	case "csv":
+		return WriteTaxReportCSV(w, report)
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	return errors.New("Unsupported report format: " + *format)
+}
+
+// BuildTaxReport groups a book's disposals into tax years starting on the given month and day
+func BuildTaxReport(book *Book, currency string, month time.Month, day int) *TaxReport {
+	years := map[int]*TaxYear{}
+	for _, disposal := range book.Disposals {
+		year := disposal.Disposed.Year()
+		if disposal.Disposed.Before(time.Date(year, month, day, 0, 0, 0, 0, disposal.Disposed.Location())) {
+			year = year - 1
+		}
+		taxYear, ok := years[year]
+		if !ok {
+			taxYear = &TaxYear{Year: year, Start: time.Date(year, month, day, 0, 0, 0, 0, time.UTC), Disposals: []Disposal{}}
+			years[year] = taxYear
+		}
+		taxYear.Proceeds = taxYear.Proceeds + disposal.Proceeds
+		taxYear.Cost = taxYear.Cost + disposal.Cost
+		taxYear.Gain = taxYear.Gain + disposal.Gain
+		taxYear.Disposals = append(taxYear.Disposals, disposal)
+	}
+
+	report := &TaxReport{Currency: currency, Method: book.Method, Years: []*TaxYear{}}
+	for _, taxYear := range years {
+		report.Years = append(report.Years, taxYear)
+	}
+	sort.Slice(report.Years, func(i, j int) bool {
+		return report.Years[i].Year < report.Years[j].Year
+	})
+	return report
+}
+
+// WriteTaxReportCSV writes one row per matched disposal followed by a total row per tax year
+func WriteTaxReportCSV(w io.Writer, report *TaxReport) error {
+	rows := [][]string{{"Tax Year", "Account", "Coin", "Acquired", "Disposed", "Amount", "Proceeds", "Cost", "Gain"}}
+	for _, taxYear := range report.Years {
+		year := strconv.Itoa(taxYear.Year)
+		for _, disposal := range taxYear.Disposals {
+			acquired := ""
+			if !disposal.Unmatched {
+				acquired = disposal.Acquired.UTC().Format(time.RFC3339)
+			}
+			rows = append(rows, []string{
+				year,
+				disposal.Account,
+				disposal.Coin,
+				acquired,
+				disposal.Disposed.UTC().Format(time.RFC3339),
+				FormatFloat(disposal.Amount),
+				fmt.Sprintf("%.2f", disposal.Proceeds),
+				fmt.Sprintf("%.2f", disposal.Cost),
+				fmt.Sprintf("%.2f", disposal.Gain),
+			})
+		}
+		rows = append(rows, []string{year, "Total", "", "", "", "", fmt.Sprintf("%.2f", taxYear.Proceeds), fmt.Sprintf("%.2f", taxYear.Cost), fmt.Sprintf("%.2f", taxYear.Gain)})
+	}
+	return WriteCSVTo(w, rows)
+}
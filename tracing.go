@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingConfig configures exporting OpenTelemetry traces of update cycles
+type TracingConfig struct {
+	// Endpoint is the host:port of an OTLP/HTTP collector, tracing is off when empty
+	Endpoint string `toml:"Endpoint"`
+	// Insecure sends traces over plain HTTP
+	Insecure bool `toml:"Insecure"`
+	// SampleRatio is the fraction of update cycles traced, defaulting to 1
+	SampleRatio float64 `toml:"SampleRatio"`
+}
+
+var tracer = otel.Tracer("portfolio-metrics")
+
+var fetchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "fetch_duration_seconds",
+	Help:      "Duration of each stage of an update cycle, with the trace ID as an exemplar when traced",
+	Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+}, []string{"stage"})
+
+// PrepareTracing registers the fetch duration histogram and starts exporting
+// traces when a collector is configured
+func PrepareTracing(config *Config) error {
+	prometheus.Register(fetchDuration)
+	if config.Tracing.Endpoint == "" {
+		return nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.Tracing.Endpoint)}
+	if config.Tracing.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return err
+	}
+	ratio := config.Tracing.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceNameKey.String("portfolio-metrics"))),
+	))
+	return nil
+}
+
+// TraceStage runs a stage of an update cycle in a span, recording its duration
+// in the fetch duration histogram
+func TraceStage(ctx context.Context, stage string, fn func(ctx context.Context) error) error {
+	ctx, span := tracer.Start(ctx, stage)
+	start := time.Now()
+	err := fn(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	ObserveFetch(span.SpanContext(), stage, time.Since(start))
+	return err
+}
+
+// TraceCall runs a call to a provider in a client span under the stage making
+// it, so each call made by a stage shows up separately in its trace
+func TraceCall(ctx context.Context, name string, host string, fn func(ctx context.Context) error) error {
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(semconv.NetPeerNameKey.String(host)))
+	defer span.End()
+	err := fn(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// ObserveFetch records a stage duration, attaching the trace ID as an exemplar
+// when the stage was sampled so Grafana can link to the trace
+func ObserveFetch(sc trace.SpanContext, stage string, d time.Duration) {
+	observer := fetchDuration.WithLabelValues(stage)
+	exemplars, ok := observer.(prometheus.ExemplarObserver)
+	if ok && sc.IsSampled() {
+		exemplars.ObserveWithExemplar(d.Seconds(), prometheus.Labels{"trace_id": sc.TraceID().String()})
+		return
+	}
+	observer.Observe(d.Seconds())
+}
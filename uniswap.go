@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+)
+
+// observeSelector is the selector of observe(uint32[]) on a Uniswap v3 pool
+const observeSelector = "0x883bdbfd"
+
+// DefaultTWAPWindow is the averaging window of Uniswap prices unless configured
+const DefaultTWAPWindow = 30 * time.Minute
+
+// UniswapConfig prices a coin from the time-weighted average price of a
+// Uniswap v3 pool, for tokens the price provider doesn't list
+type UniswapConfig struct {
+	// Pool is the address of the Uniswap v3 pool
+	Pool string `toml:"Pool"`
+	// Quote is the coin the pool pairs the coin with, priced by the provider
+	// unless it is the portfolio currency, e.g. ETH for a WETH pool
+	Quote string `toml:"Quote"`
+	// Token1 is set when the coin is the pool's token1 rather than token0
+	Token1 bool `toml:"Token1"`
+	// Decimals and QuoteDecimals are the precision of the two tokens
+	Decimals      int `toml:"Decimals"`
+	QuoteDecimals int `toml:"QuoteDecimals"`
+	// Window is how far back the price is averaged, defaulting to 30m
+	Window Duration `toml:"Window"`
+}
+
+// ParseUniswap checks the Uniswap sources and sets their defaults
+func ParseUniswap(config *Config) error {
+	for i := range config.Coins {
+		source := config.Coins[i].Uniswap
+		if source == nil {
+			continue
+		}
+		if source.Pool == "" || source.Quote == "" {
+			return errors.New("Uniswap source of " + config.Coins[i].Name + " needs a Pool and a Quote")
+		}
+		if source.Window.Duration == 0 {
+			source.Window.Duration = DefaultTWAPWindow
+		}
+		if source.Window.Duration < time.Second || source.Window.Duration > math.MaxUint32*time.Second {
+			return errors.New("Uniswap window of " + config.Coins[i].Name + " is out of range")
+		}
+	}
+	return nil
+}
+
+// GetUniswapPrice values a coin in currency from its pool's TWAP and the
+// provider's price of the quote coin
+func GetUniswapPrice(ctx context.Context, config *Config, prices PriceAPIResponse, source UniswapConfig, currency string) (float64, error) {
+	quotePrice, err := QuotePrice(config, prices, source.Quote, currency)
+	if err != nil {
+		return 0, err
+	}
+	tick, err := GetTWAPTick(ctx, config.EthereumRPC, source.Pool, source.Window.Duration)
+	if err != nil {
+		return 0, err
+	}
+	decimals0, decimals1 := source.Decimals, source.QuoteDecimals
+	if source.Token1 {
+		decimals0, decimals1 = decimals1, decimals0
+	}
+	// The tick is the log base 1.0001 of token1's raw amount per raw token0
+	price := math.Pow(1.0001, float64(tick)) * math.Pow(10, float64(decimals0-decimals1))
+	if source.Token1 {
+		price = 1 / price
+	}
+	return price * quotePrice, nil
+}
+
+// GetTWAPTick returns a pool's arithmetic mean tick over the window, from the
+// tick accumulators observe returns for the start and end of the window
+func GetTWAPTick(ctx context.Context, endpoint string, pool string, window time.Duration) (int64, error) {
+	seconds := int64(window / time.Second)
+	data := observeSelector +
+		fmt.Sprintf("%064x", 32) +
+		fmt.Sprintf("%064x", 2) +
+		fmt.Sprintf("%064x", seconds) +
+		fmt.Sprintf("%064x", 0)
+	result, err := EthCall(ctx, endpoint, pool, data)
+	if err != nil {
+		return 0, err
+	}
+	// observe returns (int56[] tickCumulatives, uint160[] secondsPerLiquidityCumulativeX128s)
+	offset, err := ABIWord(result, 0)
+	if err != nil {
+		return 0, err
+	}
+	if !offset.IsInt64() || offset.Int64()%32 != 0 {
+		return 0, errors.New("Bad ABI offset")
+	}
+	i := int(offset.Int64() / 32)
+	length, err := ABIWord(result, i)
+	if err != nil {
+		return 0, err
+	}
+	if length.Cmp(big.NewInt(2)) != 0 {
+		return 0, errors.New("Unexpected number of observations")
+	}
+	start, err := ABIInt(result, i+1)
+	if err != nil {
+		return 0, err
+	}
+	end, err := ABIInt(result, i+2)
+	if err != nil {
+		return 0, err
+	}
+	// Div rounds towards negative infinity for a positive divisor, as the
+	// Uniswap oracle library does
+	tick := new(big.Int).Div(new(big.Int).Sub(end, start), big.NewInt(seconds))
+	return tick.Int64(), nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WatermarkWindows are the trailing windows the portfolio's high and low are
+// tracked over, alongside all-time
+var WatermarkWindows = map[string]time.Duration{
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+	"1y":  365 * 24 * time.Hour,
+}
+
+var highGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "high",
+	Help:      "Highest total portfolio value in the snapshot history over a trailing window, or all-time",
+}, []string{"window"})
+
+var lowGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "portfolio_metrics",
+	Name:      "low",
+	Help:      "Lowest total portfolio value in the snapshot history over a trailing window, or all-time",
+}, []string{"window"})
+
+// Watermark is the highest and lowest total seen
+type Watermark struct {
+	High float64
+	Low  float64
+	Seen bool
+}
+
+// Add widens the watermark to include a total
+func (w *Watermark) Add(total float64) {
+	if !w.Seen || total > w.High {
+		w.High = total
+	}
+	if !w.Seen || total < w.Low {
+		w.Low = total
+	}
+	w.Seen = true
+}
+
+// allTimeWatermark is seeded from the whole snapshot history once, then
+// widened by each snapshot recorded after allTimeSeen
+var allTimeWatermark *Watermark
+var allTimeSeen time.Time
+
+// PrepareWatermarkGauges registers the high and low watermark metrics
+func PrepareWatermarkGauges() {
+	prometheus.Register(highGauge)
+	prometheus.Register(lowGauge)
+}
+
+// UpdateWatermarks exports the highs and lows of the total value in the
+// snapshot history. Valuations between snapshots are left out, so the
+// watermarks are the same after a restart.
+func UpdateWatermarks(config *Config) {
+	p := LoadPortfolio()
+	if p.UpdatedAt.IsZero() {
+		return
+	}
+	recent, err := RecentSnapshots()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	snapshots := recent
+	if allTimeWatermark == nil {
+		all, err := snapshotStore.Since(time.Time{})
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		allTimeWatermark = &Watermark{}
+		snapshots = all
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Time.After(allTimeSeen) {
+			allTimeSeen = snapshot.Time
+			if snapshot.Currency == p.Currency {
+				allTimeWatermark.Add(snapshot.Total)
+			}
+		}
+	}
+	SetWatermark("all", allTimeWatermark)
+
+	now := time.Now()
+	for window, length := range WatermarkWindows {
+		SetWatermark(window, SnapshotWatermark(SnapshotsSince(recent, now.Add(-length)), p.Currency))
+	}
+}
+
+// SetWatermark exports the high and low of a window once it has seen a snapshot
+func SetWatermark(window string, watermark *Watermark) {
+	if !watermark.Seen {
+		return
+	}
+	highGauge.WithLabelValues(window).Set(watermark.High)
+	lowGauge.WithLabelValues(window).Set(watermark.Low)
+}
+
+// SnapshotWatermark is the high and low total of the snapshots in a currency
+func SnapshotWatermark(snapshots []Snapshot, currency string) *Watermark {
+	watermark := &Watermark{}
+	for _, snapshot := range snapshots {
+		if snapshot.Currency == currency {
+			watermark.Add(snapshot.Total)
+		}
+	}
+	return watermark
+}
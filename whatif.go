@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// MaxWhatIfBody is the largest what-if request body accepted
+const MaxWhatIfBody = 1 << 20
+
+// errPriceFetch is returned when coins outside the portfolio could not be priced
+var errPriceFetch = errors.New("Prices could not be fetched")
+
+// WhatIfRequest is a hypothetical change to the holdings. Holdings replaces
+// the coin amounts held when set, and Deltas are then added to them.
+type WhatIfRequest struct {
+	Holdings map[string]float64 `json:"holdings"`
+	Deltas   map[string]float64 `json:"deltas"`
+}
+
+// WhatIfCoin is the valuation of a coin in a hypothetical portfolio
+type WhatIfCoin struct {
+	Name   string  `json:"name"`
+	Amount float64 `json:"amount"`
+	Price  float64 `json:"price"`
+	Value  float64 `json:"value"`
+	// Allocation is the coin's share of the hypothetical total
+	Allocation float64 `json:"allocation"`
+	// CurrentAllocation is the coin's share of the current total
+	CurrentAllocation float64 `json:"current_allocation"`
+}
+
+// WhatIfResult is a hypothetical portfolio valued at current prices.
+// Liquidity pool positions are kept as they are and included in Total.
+type WhatIfResult struct {
+	Currency string       `json:"currency"`
+	Total    float64      `json:"total"`
+	Change   float64      `json:"change"`
+	Coins    []WhatIfCoin `json:"coins"`
+}
+
+// PostWhatIf values a hypothetical set of holdings at the latest prices, so a
+// prospective trade can be evaluated before it is made
+func PostWhatIf(config *Config) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		req := &WhatIfRequest{}
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxWhatIfBody)).Decode(req)
+		if err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		p := LoadPortfolio()
+		if p.UpdatedAt.IsZero() {
+			http.Error(w, "Portfolio not valued yet", http.StatusServiceUnavailable)
+			return
+		}
+		result, err := WhatIf(r.Context(), config, p, req)
+		if err == errPriceFetch {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(RoundWhatIf(config, result))
+	}
+
+	return fn
+}
+
+// WhatIf values the holdings of a request, pricing coins not in the
+// portfolio through the price provider
+func WhatIf(ctx context.Context, config *Config, p *Portfolio, req *WhatIfRequest) (*WhatIfResult, error) {
+	amounts := map[string]float64{}
+	prices := map[string]float64{}
+	for _, value := range p.Coins {
+		amounts[value.Name] = value.Amount
+		prices[value.Name] = value.Price
+	}
+	if req.Holdings != nil {
+		amounts = map[string]float64{}
+		for coin, amount := range req.Holdings {
+			amounts[coin] = amount
+		}
+	}
+	for coin, delta := range req.Deltas {
+		amounts[coin] = amounts[coin] + delta
+	}
+
+	missing := []string{}
+	for coin, amount := range amounts {
+		if amount < 0 {
+			return nil, errors.New("Negative amount of " + coin)
+		}
+		if _, ok := prices[coin]; !ok {
+			missing = append(missing, coin)
+		}
+	}
+	if len(missing) > 0 {
+		fetched, err := FetchPrices(ctx, config, ProviderSymbols(config, missing), p.Currency)
+		if err != nil {
+			fmt.Println(err)
+			return nil, errPriceFetch
+		}
+		for _, coin := range missing {
+			for currency, price := range fetched[ProviderSymbol(config, coin)] {
+				if strings.ToLower(currency) == strings.ToLower(p.Currency) {
+					prices[coin] = price
+				}
+			}
+			if _, ok := prices[coin]; !ok {
+				return nil, errors.New("No current price for " + coin)
+			}
+		}
+	}
+
+	result := &WhatIfResult{Currency: p.Currency, Coins: []WhatIfCoin{}}
+	for _, position := range p.Positions {
+		result.Total = result.Total + position.Value
+	}
+	for coin, amount := range amounts {
+		value := WhatIfCoin{Name: coin, Amount: amount, Price: prices[coin], Value: amount * prices[coin]}
+		result.Total = result.Total + value.Value
+		result.Coins = append(result.Coins, value)
+	}
+	current := map[string]float64{}
+	for _, value := range p.Coins {
+		current[value.Name] = value.Value
+	}
+	for i := range result.Coins {
+		coin := &result.Coins[i]
+		if result.Total > 0 {
+			coin.Allocation = coin.Value / result.Total
+		}
+		if p.Total > 0 {
+			coin.CurrentAllocation = current[coin.Name] / p.Total
+		}
+	}
+	sort.Slice(result.Coins, func(i, j int) bool {
+		return result.Coins[i].Value > result.Coins[j].Value
+	})
+	result.Change = result.Total - p.Total
+	return result, nil
+}
+
+// RoundWhatIf rounds a what-if valuation to the configured precision
+func RoundWhatIf(config *Config, result *WhatIfResult) *WhatIfResult {
+	decimals := ValueDecimals(config, result.Currency)
+	rounded := *result
+	rounded.Total = Round(config, result.Total, decimals)
+	rounded.Change = Round(config, result.Change, decimals)
+	rounded.Coins = make([]WhatIfCoin, len(result.Coins))
+	for i, coin := range result.Coins {
+		coin.Amount = Round(config, coin.Amount, AmountDecimals(config, coin.Name))
+		coin.Price = Round(config, coin.Price, PriceDecimals(config, result.Currency, coin.Price))
+		coin.Value = Round(config, coin.Value, decimals)
+		rounded.Coins[i] = coin
+	}
+	return &rounded
+}